@@ -0,0 +1,401 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 告警引擎
+// 每条样本到达时按规则计算条件，满足条件进入 pending，持续时间达到 for 后转为 firing，
+// 条件不再满足时转为 resolved。当前状态保存在 alerts 表，每次状态变化记录到 alert_history 表。
+
+const (
+	alertStatePending  = "pending"
+	alertStateFiring   = "firing"
+	alertStateResolved = "resolved"
+)
+
+// Alert 告警记录，每次从 pending 开始到 resolved 结束对应一条记录
+type Alert struct {
+	ID          uint              `gorm:"primaryKey;autoIncrement" json:"id"`                 // 数据库主键
+	Rule        string            `gorm:"type:varchar(255);not null;index" json:"rule"`       // 规则名称
+	Fingerprint string            `gorm:"type:varchar(40);not null;index" json:"fingerprint"` // 规则 + 标签的指纹
+	Host        string            `gorm:"type:varchar(100);not null;index" json:"host"`       // 主机名
+	Labels      map[string]string `gorm:"type:text;serializer:json" json:"labels"`            // 样本标签
+	Severity    string            `gorm:"type:varchar(20);not null" json:"severity"`          // 告警级别
+	State       string            `gorm:"type:varchar(20);not null;index" json:"state"`       // pending、firing、resolved
+	Value       float64           `gorm:"type:decimal(20,6);not null" json:"value"`           // 最近一次计算值
+	Summary     string            `gorm:"type:varchar(255)" json:"summary"`                   // 告警描述
+	StartsAt    time.Time         `gorm:"not null" json:"starts_at"`                          // 条件首次满足的时间
+	FiredAt     *time.Time        `json:"fired_at"`                                           // 进入 firing 的时间
+	ResolvedAt  *time.Time        `json:"resolved_at"`                                        // 恢复时间
+	CreatedAt   time.Time         `json:"created_at"`                                         // 记录创建时间
+	UpdatedAt   time.Time         `json:"updated_at"`                                         // 记录更新时间
+}
+
+// TableName 指定 Alert 的表名
+func (Alert) TableName() string {
+	return "alerts"
+}
+
+// AlertHistory 告警状态变化记录
+type AlertHistory struct {
+	ID          uint      `gorm:"primaryKey;autoIncrement" json:"id"`                 // 数据库主键
+	AlertID     uint      `gorm:"not null;index" json:"alert_id"`                     // 对应的告警记录
+	Rule        string    `gorm:"type:varchar(255);not null" json:"rule"`             // 规则名称
+	Fingerprint string    `gorm:"type:varchar(40);not null;index" json:"fingerprint"` // 规则 + 标签的指纹
+	Host        string    `gorm:"type:varchar(100);not null;index" json:"host"`       // 主机名
+	State       string    `gorm:"type:varchar(20);not null" json:"state"`             // 变化后的状态
+	Value       float64   `gorm:"type:decimal(20,6);not null" json:"value"`           // 变化时的计算值
+	Note        string    `gorm:"type:varchar(255)" json:"note"`                      // 说明
+	CreatedAt   time.Time `gorm:"index" json:"created_at"`                            // 记录时间
+}
+
+// TableName 指定 AlertHistory 的表名
+func (AlertHistory) TableName() string {
+	return "alert_history"
+}
+
+// alertSignal 一次条件计算的输入，阈值规则以外的检测（如主机离线）也通过它驱动告警
+type alertSignal struct {
+	Rule     string
+	Severity string
+	Summary  string
+	Labels   map[string]string
+	Value    float64
+	For      time.Duration // 条件需要持续的时间，0 表示立即触发
+}
+
+// alertInstance 内存中未恢复的告警
+type alertInstance struct {
+	alert  *Alert
+	forDur time.Duration
+	active bool // 最近一次计算条件是否满足
+}
+
+// alertManager 保存规则和未恢复的告警
+type alertManager struct {
+	mu     sync.Mutex
+	rules  []*alertRule
+	active map[string]*alertInstance // fingerprint -> 告警
+}
+
+var alerts = &alertManager{active: make(map[string]*alertInstance)}
+
+// initAlerting 解析告警规则并从数据库恢复未结束的告警
+func initAlerting() error {
+	rules, err := parseAlertRules(conf().Alert.Rules)
+	if err != nil {
+		return err
+	}
+
+	var unresolved []Alert
+	if err := db.Where("state <> ?", alertStateResolved).Find(&unresolved).Error; err != nil {
+		return fmt.Errorf("读取未恢复的告警失败: %v", err)
+	}
+
+	alerts.mu.Lock()
+	defer alerts.mu.Unlock()
+	alerts.rules = rules
+	for i := range unresolved {
+		a := &unresolved[i]
+		inst := &alertInstance{alert: a, active: true}
+		for _, rule := range rules {
+			if rule.Name == a.Rule {
+				inst.forDur = rule.For
+			}
+		}
+		alerts.active[a.Fingerprint] = inst
+	}
+	alertLog.Info("告警规则加载成功", "rules", len(rules), "unresolved", len(unresolved))
+	return nil
+}
+
+// parseAlertRules 解析所有告警规则
+func parseAlertRules(cfgs []alertRuleConfig) ([]*alertRule, error) {
+	var rules []*alertRule
+	for _, cfg := range cfgs {
+		rule, err := parseAlertRule(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("告警规则 %q 无效: %v", cfg.Name, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// setRules 替换告警规则，并更新未恢复告警需要持续的时间
+func (m *alertManager) setRules(rules []*alertRule) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rules = rules
+	for _, inst := range m.active {
+		for _, rule := range rules {
+			if rule.Name == inst.alert.Rule {
+				inst.forDur = rule.For
+			}
+		}
+	}
+}
+
+// alertFingerprint 根据规则名和排序后的标签计算指纹
+func alertFingerprint(rule string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(rule)
+	for _, k := range keys {
+		b.WriteString("\x00" + k + "=" + labels[k])
+	}
+	sum := sha1.Sum([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// observe 使用新到达的样本计算所有匹配的规则
+func (m *alertManager) observe(metric *TelegrafJson) {
+	if !conf().Alert.Enable {
+		return
+	}
+	m.mu.Lock()
+	rules := m.rules
+	m.mu.Unlock()
+
+	now := time.Now()
+	for _, rule := range rules {
+		if rule.Measurement != metric.Name || !matchLabels(rule.Matchers, metric.Tags) {
+			continue
+		}
+		v, ok := fieldValue(metric.Fields, rule.Field)
+		if !ok {
+			continue
+		}
+		labels := make(map[string]string, len(metric.Tags))
+		for k, val := range metric.Tags {
+			labels[k] = val
+		}
+		summary := rule.Summary
+		if summary == "" {
+			summary = fmt.Sprintf("%s.%s %s %g (当前值 %.2f)", rule.Measurement, rule.Field, rule.Op, rule.Threshold, v)
+		}
+		m.update(alertSignal{
+			Rule:     rule.Name,
+			Severity: rule.Severity,
+			Summary:  summary,
+			Labels:   labels,
+			Value:    v,
+			For:      rule.For,
+		}, rule.compare(v), now)
+	}
+}
+
+// update 根据条件是否满足推进告警状态
+func (m *alertManager) update(sig alertSignal, cond bool, now time.Time) {
+	fp := alertFingerprint(sig.Rule, sig.Labels)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inst, exists := m.active[fp]
+	if !cond {
+		if !exists {
+			return
+		}
+		inst.alert.Value = sig.Value
+		if inst.alert.State == alertStateFiring {
+			m.transition(inst, alertStateResolved, now, "条件恢复")
+		} else {
+			m.transition(inst, alertStateResolved, now, "未达到持续时间即恢复")
+		}
+		delete(m.active, fp)
+		return
+	}
+
+	if !exists {
+		inst = &alertInstance{
+			alert: &Alert{
+				Rule:        sig.Rule,
+				Fingerprint: fp,
+				Host:        sig.Labels["host"],
+				Labels:      sig.Labels,
+				Severity:    sig.Severity,
+				Summary:     sig.Summary,
+				StartsAt:    now,
+			},
+			forDur: sig.For,
+		}
+		m.active[fp] = inst
+	}
+	inst.active = true
+	inst.forDur = sig.For
+	inst.alert.Value = sig.Value
+	inst.alert.Summary = sig.Summary
+
+	switch {
+	case !exists && sig.For <= 0:
+		m.transition(inst, alertStateFiring, now, "条件满足")
+	case !exists:
+		m.transition(inst, alertStatePending, now, "条件满足，等待持续时间")
+	case inst.alert.State == alertStatePending && now.Sub(inst.alert.StartsAt) >= inst.forDur:
+		m.transition(inst, alertStateFiring, now, "持续时间已满足")
+	}
+}
+
+// isActive 判断信号对应的告警是否尚未恢复
+func (m *alertManager) isActive(sig alertSignal) bool {
+	fp := alertFingerprint(sig.Rule, sig.Labels)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.active[fp]
+	return ok
+}
+
+// tick 定时检查 pending 告警，在没有新样本时也能按持续时间转为 firing
+func (m *alertManager) tick(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, inst := range m.active {
+		if inst.active && inst.alert.State == alertStatePending && now.Sub(inst.alert.StartsAt) >= inst.forDur {
+			m.transition(inst, alertStateFiring, now, "持续时间已满足")
+		}
+	}
+}
+
+// transition 修改告警状态并写入数据库和历史记录，调用方需持有锁
+func (m *alertManager) transition(inst *alertInstance, state string, now time.Time, note string) {
+	a := inst.alert
+	prev := a.State
+	a.State = state
+	switch state {
+	case alertStateFiring:
+		a.FiredAt = &now
+	case alertStateResolved:
+		a.ResolvedAt = &now
+	}
+
+	if err := db.Save(a).Error; err != nil {
+		alertLog.Error("保存告警状态失败", "rule", a.Rule, "err", err)
+		return
+	}
+	recordAlertHistory(a, state, note)
+
+	// 只有触发过的告警才需要通知
+	if state == alertStateFiring || (state == alertStateResolved && prev == alertStateFiring) {
+		notifyAlert(a)
+	}
+}
+
+// recordAlertHistory 写入一条告警历史
+func recordAlertHistory(a *Alert, state, note string) {
+	history := AlertHistory{
+		AlertID:     a.ID,
+		Rule:        a.Rule,
+		Fingerprint: a.Fingerprint,
+		Host:        a.Host,
+		State:       state,
+		Value:       a.Value,
+		Note:        note,
+	}
+	if err := db.Create(&history).Error; err != nil {
+		alertLog.Error("保存告警历史失败", "rule", a.Rule, "err", err)
+	}
+}
+
+// notifyAlert 输出告警触发和恢复信息，并放入通知队列
+// 匹配静默或维护窗口的告警不发送通知，只记录一条 suppressed 历史
+func notifyAlert(a *Alert) {
+	if a.State == alertStateFiring {
+		alertLog.Warn("告警触发", "severity", a.Severity, "rule", a.Rule, "summary", a.Summary, "host", a.Host)
+	} else {
+		alertLog.Info("告警恢复", "severity", a.Severity, "rule", a.Rule, "summary", a.Summary, "host", a.Host)
+	}
+	if reason := silences.suppressedBy(a, time.Now()); reason != "" {
+		recordAlertHistory(a, alertStateSuppressed, fmt.Sprintf("%s 通知被%s抑制", a.State, reason))
+		return
+	}
+	enqueueNotify(a)
+}
+
+// evaluateAlerts 定时任务，推进 pending 告警
+func evaluateAlerts() error {
+	alerts.tick(time.Now())
+	return nil
+}
+
+// queryLimit 读取 limit 参数，默认 100，最大 1000
+func queryLimit(r *http.Request) int {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		return 100
+	}
+	if limit > 1000 {
+		return 1000
+	}
+	return limit
+}
+
+// handleAlerts 查询告警记录，参数: state、host、rule、limit
+func handleAlerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "只接受 GET 请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	tx := db.Order("id DESC").Limit(queryLimit(r))
+	if state := query.Get("state"); state != "" {
+		tx = tx.Where("state = ?", state)
+	}
+	if host := query.Get("host"); host != "" {
+		tx = tx.Where("host = ?", host)
+	}
+	if rule := query.Get("rule"); rule != "" {
+		tx = tx.Where("rule = ?", rule)
+	}
+
+	var result []Alert
+	if err := tx.Find(&result).Error; err != nil {
+		http.Error(w, "查询告警失败", http.StatusInternalServerError)
+		apiLog.Error("查询告警失败", "err", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleAlertHistory 查询告警历史，参数: alert_id、fingerprint、host、limit
+func handleAlertHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "只接受 GET 请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	tx := db.Order("id DESC").Limit(queryLimit(r))
+	if alertID := query.Get("alert_id"); alertID != "" {
+		tx = tx.Where("alert_id = ?", alertID)
+	}
+	if fp := query.Get("fingerprint"); fp != "" {
+		tx = tx.Where("fingerprint = ?", fp)
+	}
+	if host := query.Get("host"); host != "" {
+		tx = tx.Where("host = ?", host)
+	}
+
+	var result []AlertHistory
+	if err := tx.Find(&result).Error; err != nil {
+		http.Error(w, "查询告警历史失败", http.StatusInternalServerError)
+		apiLog.Error("查询告警历史失败", "err", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
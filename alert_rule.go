@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 告警规则解析
+// 规则表达式格式: <measurement>.<field> <op> <阈值> [for <持续时间>] [on <标签匹配>[,<标签匹配>...]]
+// 例如:
+//   disk.used_percent > 90 for 10m on path=/
+//   cpu.usage_iowait > 30 for 5m on host=pi4-2gb,cpu=cpu-total
+// 标签匹配支持 key=value、key!=value、key=~正则
+
+// alertRuleConfig 配置文件中的单条告警规则
+type alertRuleConfig struct {
+	Name     string `json:"name"`     // 规则名称，为空时使用表达式
+	Expr     string `json:"expr"`     // 规则表达式
+	Severity string `json:"severity"` // 告警级别，默认 warning
+	Summary  string `json:"summary"`  // 告警描述
+}
+
+// alertConfig 告警配置
+type alertConfig struct {
+	Enable bool              `json:"enable"`
+	Rules  []alertRuleConfig `json:"rules"`
+}
+
+// labelMatcher 单个标签匹配条件
+type labelMatcher struct {
+	Key   string
+	Op    string // =、!=、=~
+	Value string
+	re    *regexp.Regexp
+}
+
+// matches 判断标签集合是否满足条件，缺失的标签按空字符串处理
+func (m labelMatcher) matches(labels map[string]string) bool {
+	v := labels[m.Key]
+	switch m.Op {
+	case "!=":
+		return v != m.Value
+	case "=~":
+		return m.re.MatchString(v)
+	default:
+		return v == m.Value
+	}
+}
+
+// alertRule 解析后的告警规则
+type alertRule struct {
+	Name        string
+	Severity    string
+	Summary     string
+	Measurement string
+	Field       string
+	Op          string
+	Threshold   float64
+	For         time.Duration
+	Matchers    []labelMatcher
+}
+
+// parseAlertRule 解析配置中的告警规则
+func parseAlertRule(cfg alertRuleConfig) (*alertRule, error) {
+	tokens := strings.Fields(cfg.Expr)
+	if len(tokens) < 3 {
+		return nil, fmt.Errorf("规则表达式不完整: %q", cfg.Expr)
+	}
+
+	rule := &alertRule{
+		Name:     cfg.Name,
+		Severity: cfg.Severity,
+		Summary:  cfg.Summary,
+	}
+	if rule.Name == "" {
+		rule.Name = cfg.Expr
+	}
+	if rule.Severity == "" {
+		rule.Severity = "warning"
+	}
+
+	measurement, field, ok := strings.Cut(tokens[0], ".")
+	if !ok || measurement == "" || field == "" {
+		return nil, fmt.Errorf("指标名称应为 measurement.field 格式: %q", tokens[0])
+	}
+	rule.Measurement = measurement
+	rule.Field = field
+
+	switch tokens[1] {
+	case ">", ">=", "<", "<=", "==", "!=":
+		rule.Op = tokens[1]
+	default:
+		return nil, fmt.Errorf("不支持的比较运算符: %q", tokens[1])
+	}
+
+	threshold, err := strconv.ParseFloat(tokens[2], 64)
+	if err != nil {
+		return nil, fmt.Errorf("无法解析阈值 %q: %v", tokens[2], err)
+	}
+	rule.Threshold = threshold
+
+	rest := tokens[3:]
+	if len(rest) >= 2 && rest[0] == "for" {
+		d, err := time.ParseDuration(rest[1])
+		if err != nil {
+			return nil, fmt.Errorf("无法解析持续时间 %q: %v", rest[1], err)
+		}
+		rule.For = d
+		rest = rest[2:]
+	}
+	if len(rest) >= 2 && rest[0] == "on" {
+		matchers, err := parseLabelMatchers(strings.Join(rest[1:], ""))
+		if err != nil {
+			return nil, err
+		}
+		rule.Matchers = matchers
+		rest = nil
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("无法识别的规则内容: %q", strings.Join(rest, " "))
+	}
+	return rule, nil
+}
+
+// parseLabelMatchers 解析以逗号分隔的标签匹配条件
+func parseLabelMatchers(s string) ([]labelMatcher, error) {
+	var matchers []labelMatcher
+	for _, part := range strings.Split(s, ",") {
+		if part == "" {
+			continue
+		}
+		var m labelMatcher
+		switch {
+		case strings.Contains(part, "=~"):
+			m.Key, m.Value, _ = strings.Cut(part, "=~")
+			m.Op = "=~"
+		case strings.Contains(part, "!="):
+			m.Key, m.Value, _ = strings.Cut(part, "!=")
+			m.Op = "!="
+		case strings.Contains(part, "="):
+			m.Key, m.Value, _ = strings.Cut(part, "=")
+			m.Op = "="
+		default:
+			return nil, fmt.Errorf("无法解析标签匹配条件: %q", part)
+		}
+		if m.Key == "" {
+			return nil, fmt.Errorf("标签匹配条件缺少标签名: %q", part)
+		}
+		if m.Op == "=~" {
+			re, err := regexp.Compile("^(?:" + m.Value + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("无法解析正则 %q: %v", m.Value, err)
+			}
+			m.re = re
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+// matchLabels 判断标签集合是否满足全部匹配条件
+func matchLabels(matchers []labelMatcher, labels map[string]string) bool {
+	for _, m := range matchers {
+		if !m.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// compare 判断数值是否满足规则条件
+func (r *alertRule) compare(v float64) bool {
+	switch r.Op {
+	case ">":
+		return v > r.Threshold
+	case ">=":
+		return v >= r.Threshold
+	case "<":
+		return v < r.Threshold
+	case "<=":
+		return v <= r.Threshold
+	case "==":
+		return v == r.Threshold
+	default:
+		return v != r.Threshold
+	}
+}
+
+// fieldValue 从 fields 中读取数值字段
+func fieldValue(fields map[string]interface{}, name string) (float64, bool) {
+	switch v := fields[name].(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
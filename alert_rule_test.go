@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAlertRule(t *testing.T) {
+	tests := []struct {
+		expr      string
+		field     string
+		op        string
+		threshold float64
+		forDur    time.Duration
+		matchers  int
+	}{
+		{"cpu.usage_active > 90", "usage_active", ">", 90, 0, 0},
+		{"mem.used_percent >= 85.5 for 5m", "used_percent", ">=", 85.5, 5 * time.Minute, 0},
+		{"disk.used_percent > 90 on path=/", "used_percent", ">", 90, 0, 1},
+		{"net.err_in != 0 for 1m on host=~web-.*, interface!=lo", "err_in", "!=", 0, time.Minute, 2},
+		{"cpu.usage_idle < -1", "usage_idle", "<", -1, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			r, err := parseAlertRule(alertRuleConfig{Expr: tt.expr})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Field != tt.field || r.Op != tt.op || r.Threshold != tt.threshold || r.For != tt.forDur || len(r.Matchers) != tt.matchers {
+				t.Errorf("解析结果 %+v", r)
+			}
+			// 未指定名称和级别时使用表达式和 warning
+			if r.Name != tt.expr || r.Severity != "warning" {
+				t.Errorf("名称 %q、级别 %q", r.Name, r.Severity)
+			}
+		})
+	}
+}
+
+func TestParseAlertRuleErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"cpu.usage_active >",
+		"cpu > 90",
+		".usage_active > 90",
+		"cpu.usage_active => 90",
+		"cpu.usage_active > high",
+		"cpu.usage_active > 90 for soon",
+		"cpu.usage_active > 90 on host",
+		"cpu.usage_active > 90 on host=~(",
+		"cpu.usage_active > 90 extra",
+	} {
+		if _, err := parseAlertRule(alertRuleConfig{Expr: expr}); err == nil {
+			t.Errorf("parseAlertRule(%q) 期望出错", expr)
+		}
+	}
+}
+
+func TestLabelMatchers(t *testing.T) {
+	matchers, err := parseLabelMatchers("host=~web-.*,interface!=lo,env=prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		labels map[string]string
+		want   bool
+	}{
+		{map[string]string{"host": "web-1", "interface": "eth0", "env": "prod"}, true},
+		{map[string]string{"host": "db-1", "interface": "eth0", "env": "prod"}, false},
+		{map[string]string{"host": "web-1", "interface": "lo", "env": "prod"}, false},
+		// 正则需要完整匹配
+		{map[string]string{"host": "xweb-1", "interface": "eth0", "env": "prod"}, false},
+		// 缺失的标签按空字符串处理
+		{map[string]string{"host": "web-1", "env": "prod"}, true},
+		{map[string]string{"host": "web-1"}, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := matchLabels(matchers, tt.labels); got != tt.want {
+			t.Errorf("matchLabels(%v) = %v，期望 %v", tt.labels, got, tt.want)
+		}
+	}
+}
+
+func TestAlertRuleCompare(t *testing.T) {
+	tests := []struct {
+		op   string
+		v    float64
+		want bool
+	}{
+		{">", 90, false},
+		{">", 90.1, true},
+		{">=", 90, true},
+		{"<", 90, false},
+		{"<=", 90, true},
+		{"==", 90, true},
+		{"!=", 90, false},
+		{"!=", 0, true},
+	}
+	for _, tt := range tests {
+		r := &alertRule{Op: tt.op, Threshold: 90}
+		if got := r.compare(tt.v); got != tt.want {
+			t.Errorf("%v %s 90 = %v，期望 %v", tt.v, tt.op, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// 异常检测
+// 为每个主机学习按“周内小时”（hour-of-week）划分的季节性基线（均值和标准差），
+// 每小时检查上一个完整小时的 CPU 使用率、内存使用率和网卡小时流量，
+// 偏离基线超过 threshold 个标准差时记录异常事件并触发 anomaly 告警。
+
+// anomalyConfig 异常检测配置
+type anomalyConfig struct {
+	Enable     bool    `json:"enable"`
+	Weeks      int     `json:"weeks"`       // 基线使用的历史周数，默认 4
+	Threshold  float64 `json:"threshold"`   // z-score 阈值，默认 3
+	MinSamples int     `json:"min_samples"` // 同一周内小时的最少历史样本数，默认 3
+}
+
+// AnomalyEvent 异常事件
+type AnomalyEvent struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`             // 数据库主键
+	Host      string    `gorm:"type:varchar(100);not null;index" json:"host"`   // 主机名
+	Series    string    `gorm:"type:varchar(100);not null;index" json:"series"` // 指标，如 cpu.usage_active
+	Interface string    `gorm:"type:varchar(50);not null" json:"interface"`     // 网卡接口名（仅网络流量）
+	Hour      time.Time `gorm:"not null;index" json:"hour"`                     // 异常所在小时
+	Value     float64   `gorm:"type:decimal(20,6);not null" json:"value"`       // 实际值
+	Mean      float64   `gorm:"type:decimal(20,6);not null" json:"mean"`        // 基线均值
+	Stddev    float64   `gorm:"type:decimal(20,6);not null" json:"stddev"`      // 基线标准差
+	ZScore    float64   `gorm:"type:decimal(10,4);not null" json:"z_score"`     // 偏离的标准差倍数
+	CreatedAt time.Time `json:"created_at"`                                     // 记录创建时间
+}
+
+// TableName 指定 AnomalyEvent 的表名
+func (AnomalyEvent) TableName() string {
+	return "anomaly_events"
+}
+
+// anomalyPoint 按小时聚合后的样本
+type anomalyPoint struct {
+	Host      string
+	Interface string
+	Hour      time.Time
+	Value     float64
+}
+
+// anomalySeriesKey 检测序列的键
+type anomalySeriesKey struct {
+	Series    string
+	Host      string
+	Interface string
+}
+
+// hourOfWeek 返回本地时间的周内小时 (0-167)
+func hourOfWeek(t time.Time) int {
+	return int(t.Weekday())*24 + t.Hour()
+}
+
+// meanStddev 计算均值和总体标准差
+func meanStddev(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(sq / float64(len(values)))
+}
+
+// bucketPoint 数据库按小时聚合的结果，Bucket 为 Unix 小时数
+type bucketPoint struct {
+	Host   string
+	Bucket int64
+	Value  float64
+}
+
+// fetchHourlyPoints 读取 [from, to) 区间内 CPU、内存和网卡流量的小时聚合值
+func fetchHourlyPoints(from, to time.Time) (map[anomalySeriesKey][]anomalyPoint, error) {
+	result := make(map[anomalySeriesKey][]anomalyPoint)
+	add := func(series string, p anomalyPoint) {
+		k := anomalySeriesKey{Series: series, Host: p.Host, Interface: p.Interface}
+		result[k] = append(result[k], p)
+	}
+
+	var cpuPoints []bucketPoint
+	if err := db.Model(&CPUFieldsDb{}).
+		Select("host, FLOOR(timestamp / 3600) AS bucket, AVG(usage_active) AS value").
+		Where("cpu = ? AND timestamp >= ? AND timestamp < ?", "cpu-total", from.Unix(), to.Unix()).
+		Group("host, FLOOR(timestamp / 3600)").
+		Scan(&cpuPoints).Error; err != nil {
+		return nil, fmt.Errorf("读取 CPU 数据失败: %v", err)
+	}
+	for _, p := range cpuPoints {
+		add("cpu.usage_active", anomalyPoint{Host: p.Host, Hour: time.Unix(p.Bucket*3600, 0), Value: p.Value})
+	}
+
+	var memPoints []bucketPoint
+	if err := db.Model(&MemFieldsDb{}).
+		Select("host, FLOOR(timestamp / 3600) AS bucket, AVG(used_percent) AS value").
+		Where("timestamp >= ? AND timestamp < ?", from.Unix(), to.Unix()).
+		Group("host, FLOOR(timestamp / 3600)").
+		Scan(&memPoints).Error; err != nil {
+		return nil, fmt.Errorf("读取内存数据失败: %v", err)
+	}
+	for _, p := range memPoints {
+		add("mem.used_percent", anomalyPoint{Host: p.Host, Hour: time.Unix(p.Bucket*3600, 0), Value: p.Value})
+	}
+
+	var netPoints []anomalyPoint
+	if err := db.Model(&NetInterfaceCollectHour{}).
+		Select("host, interface, hour, total AS value").
+		Where("hour >= ? AND hour < ?", from, to).
+		Scan(&netPoints).Error; err != nil {
+		return nil, fmt.Errorf("读取网络流量数据失败: %v", err)
+	}
+	for _, p := range netPoints {
+		add("net.total_mb", p)
+	}
+	return result, nil
+}
+
+// detectAnomalies 定时任务，检查上一个完整小时是否偏离季节性基线，ctx 取消后不再写入
+func detectAnomalies(ctx context.Context) error {
+	cronLog.Info("detectAnomalies 执行中")
+	weeks := conf().Anomaly.Weeks
+	if weeks <= 0 {
+		weeks = 4
+	}
+	threshold := conf().Anomaly.Threshold
+	if threshold <= 0 {
+		threshold = 3
+	}
+	minSamples := conf().Anomaly.MinSamples
+	if minSamples <= 0 {
+		minSamples = 3
+	}
+
+	now := time.Now()
+	target := bucketStart(now, time.Hour).Add(-time.Hour)
+	from := target.AddDate(0, 0, -7*weeks)
+	points, err := fetchHourlyPoints(from, target.Add(time.Hour))
+	if err != nil {
+		return fmt.Errorf("failed to fetch anomaly data: %v", err)
+	}
+
+	targetHow := hourOfWeek(target)
+	var events []AnomalyEvent
+	for k, series := range points {
+		var history []float64
+		var current *anomalyPoint
+		for i := range series {
+			p := &series[i]
+			if !p.Hour.Before(target) {
+				current = p
+				continue
+			}
+			if hourOfWeek(p.Hour) == targetHow {
+				history = append(history, p.Value)
+			}
+		}
+		if current == nil || len(history) < minSamples {
+			continue
+		}
+
+		mean, stddev := meanStddev(history)
+		if stddev < 1e-9 {
+			continue
+		}
+		z := (current.Value - mean) / stddev
+		isAnomaly := math.Abs(z) >= threshold
+		if isAnomaly {
+			events = append(events, AnomalyEvent{
+				Host:      k.Host,
+				Series:    k.Series,
+				Interface: k.Interface,
+				Hour:      target,
+				Value:     current.Value,
+				Mean:      mean,
+				Stddev:    stddev,
+				ZScore:    z,
+			})
+		}
+
+		labels := map[string]string{"host": k.Host, "series": k.Series}
+		if k.Interface != "" {
+			labels["interface"] = k.Interface
+		}
+		alerts.update(alertSignal{
+			Rule:     "anomaly",
+			Severity: "warning",
+			Summary: fmt.Sprintf("%s 在 %s 的值 %.2f 偏离基线 %.2f±%.2f (z=%.2f)",
+				k.Series, target.Format("01-02 15:04"), current.Value, mean, stddev, z),
+			Labels: labels,
+			Value:  z,
+		}, isAnomaly, now)
+	}
+
+	if len(events) > 0 {
+		if err := db.WithContext(ctx).CreateInBatches(events, 100).Error; err != nil {
+			return fmt.Errorf("failed to insert anomaly events: %v", err)
+		}
+	}
+	cronLog.Info("detectAnomalies 成功", "series", len(points), "anomalies", len(events))
+	return nil
+}
+
+// handleAnomalies 查询异常事件，参数: host、series、limit
+func handleAnomalies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "只接受 GET 请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	tx := db.Order("id DESC").Limit(queryLimit(r))
+	if host := query.Get("host"); host != "" {
+		tx = tx.Where("host = ?", host)
+	}
+	if series := query.Get("series"); series != "" {
+		tx = tx.Where("series = ?", series)
+	}
+
+	var result []AnomalyEvent
+	if err := tx.Find(&result).Error; err != nil {
+		http.Error(w, "查询异常事件失败", http.StatusInternalServerError)
+		apiLog.Error("查询异常事件失败", "err", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 写入接口认证
+// 支持客户端证书（见 tls.go）、静态 Bearer Token 和 Basic Auth（密码以 bcrypt 哈希保存在配置中），
+// 每个 Token/用户可以限制允许写入的 host 标签。认证失败返回 401，写入不允许的主机返回 403。
+// 手动执行任务等管理接口只允许 admin 为 true 的 Token/用户调用，与 enable 无关。
+
+// authConfig 写入接口认证配置
+type authConfig struct {
+	Enable bool        `json:"enable"`
+	Tokens []authToken `json:"tokens"`
+	Users  []authUser  `json:"users"`
+}
+
+// authToken 静态 Bearer Token
+type authToken struct {
+	Name  string   `json:"name"`  // 名称，用于日志
+	Token string   `json:"token"` // Token 值
+	Hosts []string `json:"hosts"` // 允许写入的 host 标签（支持通配符），为空时不限制
+	Admin bool     `json:"admin"` // 允许调用管理接口
+}
+
+// authUser Basic Auth 用户
+type authUser struct {
+	Username     string   `json:"username"`
+	PasswordHash string   `json:"password_hash"` // bcrypt 哈希，可用 htpasswd -nbB 生成
+	Hosts        []string `json:"hosts"`         // 允许写入的 host 标签（支持通配符），为空时不限制
+	Admin        bool     `json:"admin"`         // 允许调用管理接口
+}
+
+// principal 认证通过的调用方
+type principal struct {
+	Name  string
+	Hosts []string
+	Admin bool
+}
+
+// allowsHost 判断调用方是否可以写入该主机的数据
+func (p *principal) allowsHost(host string) bool {
+	if len(p.Hosts) == 0 {
+		return true
+	}
+	for _, pattern := range p.Hosts {
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// forbiddenHost 返回第一个不允许写入的主机，全部允许时返回空字符串
+func (p *principal) forbiddenHost(metrics []TelegrafJson) (string, bool) {
+	for _, m := range metrics {
+		host := m.Tags["host"]
+		if !p.allowsHost(host) {
+			return host, true
+		}
+	}
+	return "", false
+}
+
+type principalKey struct{}
+
+// principalFrom 读取请求中已认证的调用方，未启用认证时返回 nil
+func principalFrom(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalKey{}).(*principal)
+	return p
+}
+
+// bcrypt 校验较慢，缓存校验通过的用户名和密码摘要
+var basicAuthCache sync.Map // username -> [32]byte
+
+// authenticate 校验请求的 Bearer Token 或 Basic Auth
+func authenticate(r *http.Request) (*principal, bool) {
+	header := r.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		for _, t := range conf().Auth.Tokens {
+			if t.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t.Token)) == 1 {
+				return &principal{Name: t.Name, Hosts: t.Hosts, Admin: t.Admin}, true
+			}
+		}
+		return nil, false
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, false
+	}
+	for _, u := range conf().Auth.Users {
+		if u.Username != username {
+			continue
+		}
+		digest := sha256.Sum256([]byte(u.PasswordHash + "\x00" + password))
+		if cached, ok := basicAuthCache.Load(username); ok && cached.([32]byte) == digest {
+			return &principal{Name: username, Hosts: u.Hosts, Admin: u.Admin}, true
+		}
+		if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+			return nil, false
+		}
+		basicAuthCache.Store(username, digest)
+		return &principal{Name: username, Hosts: u.Hosts, Admin: u.Admin}, true
+	}
+	return nil, false
+}
+
+// requireIngestAuth 为写入接口增加认证
+// 已校验的客户端证书优先，其次是 Token/Basic Auth，未启用认证时直接放行
+func requireIngestAuth(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if p := certPrincipal(r); p != nil {
+			next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+			return
+		}
+		if !conf().Auth.Enable {
+			next(w, r)
+			return
+		}
+		p, ok := authenticate(r)
+		if !ok {
+			countRejected(endpoint, http.StatusUnauthorized)
+			ingestLog.Warn("拒绝未认证的写入请求", "endpoint", endpoint, "remote", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Basic realm="MonitorCollect"`)
+			http.Error(w, "未认证", http.StatusUnauthorized)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	}
+}
+
+// requireAdminAuth 为管理接口增加认证，只允许 admin 为 true 的 Token/用户，未认证返回 401，无权限返回 403
+func requireAdminAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := authenticate(r)
+		if !ok {
+			apiLog.Warn("拒绝未认证的管理请求", "path", r.URL.Path, "remote", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Basic realm="MonitorCollect"`)
+			http.Error(w, "未认证", http.StatusUnauthorized)
+			return
+		}
+		if !p.Admin {
+			apiLog.Warn("拒绝管理请求: 没有管理员权限", "principal", p.Name, "path", r.URL.Path)
+			http.Error(w, "没有管理员权限", http.StatusForbidden)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	}
+}
+
+// checkHostAllowed 校验调用方是否可以写入这批数据，不允许时返回 403
+func checkHostAllowed(w http.ResponseWriter, r *http.Request, endpoint string, metrics []TelegrafJson) bool {
+	p := principalFrom(r.Context())
+	if p == nil {
+		return true
+	}
+	if host, forbidden := p.forbiddenHost(metrics); forbidden {
+		countRejected(endpoint, http.StatusForbidden)
+		ingestLog.Warn("拒绝写入请求: 无权写入该主机的数据", "principal", p.Name, "host", host, "endpoint", endpoint)
+		http.Error(w, "无权写入该主机的数据", http.StatusForbidden)
+		return false
+	}
+	return true
+}
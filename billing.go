@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 95 计费报表
+// 基于 5 分钟流量汇总 (net_interface_collect_5min) 计算账期内每个网卡的 95 百分位速率。
+
+// percentileReport 单个网卡的 95 计费结果，速率单位为 bits/s
+type percentileReport struct {
+	Host      string    `json:"host"`
+	Interface string    `json:"interface"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Samples   int       `json:"samples"`     // 账期内 5 分钟样本数
+	RxP95     float64   `json:"rx_p95_bps"`  // 接收速率 95 百分位
+	TxP95     float64   `json:"tx_p95_bps"`  // 发送速率 95 百分位
+	MaxP95    float64   `json:"max_p95_bps"` // 每个样本取 max(rx, tx) 后的 95 百分位
+	RxP95Str  string    `json:"rx_p95"`      // 格式化后的接收速率
+	TxP95Str  string    `json:"tx_p95"`      // 格式化后的发送速率
+	MaxP95Str string    `json:"max_p95"`     // 格式化后的计费速率
+}
+
+// percentile95 计算 95 百分位：升序排列后丢弃最高的 5% 样本，取剩余的最大值
+func percentile95(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	idx := int(math.Ceil(float64(len(sorted))*0.95)) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
+
+// buildPercentileReport 查询 [from, to) 区间的 5 分钟数据并按主机/网卡计算 95 百分位
+// host、iface 为空时不过滤
+func buildPercentileReport(from, to time.Time, host, iface string) ([]percentileReport, error) {
+	var rows []NetInterfaceCollect5Min
+	tx := db.Where("bucket >= ? AND bucket < ?", from, to)
+	if host != "" {
+		tx = tx.Where("host = ?", host)
+	}
+	if iface != "" {
+		tx = tx.Where("interface = ?", iface)
+	}
+	if err := tx.Order("host, interface, bucket").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	type series struct {
+		rx, tx, max []float64
+	}
+	type seriesKey struct {
+		Host      string
+		Interface string
+	}
+	var keys []seriesKey
+	seriesMap := make(map[seriesKey]*series)
+	for _, row := range rows {
+		k := seriesKey{Host: row.Host, Interface: row.Interface}
+		s, ok := seriesMap[k]
+		if !ok {
+			s = &series{}
+			seriesMap[k] = s
+			keys = append(keys, k)
+		}
+		s.rx = append(s.rx, row.RecvBps)
+		s.tx = append(s.tx, row.SentBps)
+		s.max = append(s.max, math.Max(row.RecvBps, row.SentBps))
+	}
+
+	reports := make([]percentileReport, 0, len(keys))
+	for _, k := range keys {
+		s := seriesMap[k]
+		r := percentileReport{
+			Host:      k.Host,
+			Interface: k.Interface,
+			From:      from,
+			To:        to,
+			Samples:   len(s.rx),
+			RxP95:     percentile95(s.rx),
+			TxP95:     percentile95(s.tx),
+			MaxP95:    percentile95(s.max),
+		}
+		r.RxP95Str = formatNetSpeed(r.RxP95)
+		r.TxP95Str = formatNetSpeed(r.TxP95)
+		r.MaxP95Str = formatNetSpeed(r.MaxP95)
+		reports = append(reports, r)
+	}
+	return reports, nil
+}
+
+// writePercentileCSV 以 CSV 格式输出 95 计费报表
+func writePercentileCSV(w *csv.Writer, reports []percentileReport) error {
+	header := []string{"host", "interface", "from", "to", "samples", "rx_p95_bps", "tx_p95_bps", "max_p95_bps"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, r := range reports {
+		record := []string{
+			r.Host,
+			r.Interface,
+			r.From.Format(time.RFC3339),
+			r.To.Format(time.RFC3339),
+			strconv.Itoa(r.Samples),
+			strconv.FormatFloat(r.RxP95, 'f', 2, 64),
+			strconv.FormatFloat(r.TxP95, 'f', 2, 64),
+			strconv.FormatFloat(r.MaxP95, 'f', 2, 64),
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// parseTimeParam 解析时间参数，支持 RFC3339、"2006-01-02 15:04:05"、"2006-01-02" 和 Unix 秒
+func parseTimeParam(value string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	layouts := []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("无法解析时间: %s", value)
+}
+
+// parseTimeRange 从查询参数中读取 from/to，to 为空时取当前时间
+func parseTimeRange(r *http.Request) (time.Time, time.Time, error) {
+	fromStr := r.URL.Query().Get("from")
+	if fromStr == "" {
+		return time.Time{}, time.Time{}, fmt.Errorf("缺少 from 参数")
+	}
+	from, err := parseTimeParam(fromStr)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	to := time.Now()
+	if toStr := r.URL.Query().Get("to"); toStr != "" {
+		if to, err = parseTimeParam(toStr); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	if !to.After(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("to 必须晚于 from")
+	}
+	return from, to, nil
+}
+
+// handlePercentileReport 返回指定账期的 95 计费报表
+// 参数: from、to、host、interface、format=json|csv
+func handlePercentileReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "只接受 GET 请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	reports, err := buildPercentileReport(from, to, query.Get("host"), query.Get("interface"))
+	if err != nil {
+		http.Error(w, "查询 95 计费数据失败", http.StatusInternalServerError)
+		apiLog.Error("查询 95 计费数据失败", "err", err)
+		return
+	}
+
+	if query.Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition",
+			fmt.Sprintf("attachment; filename=p95_%s_%s.csv", from.Format("20060102"), to.Format("20060102")))
+		if err := writePercentileCSV(csv.NewWriter(w), reports); err != nil {
+			apiLog.Error("输出 95 计费 CSV 出错", "err", err)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, reports)
+}
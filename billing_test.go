@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestPercentile95(t *testing.T) {
+	seq := func(n int) []float64 {
+		values := make([]float64, n)
+		for i := range values {
+			// 倒序写入，确认会先排序
+			values[i] = float64(n - i)
+		}
+		return values
+	}
+	tests := []struct {
+		name   string
+		values []float64
+		want   float64
+	}{
+		{"没有样本", nil, 0},
+		{"1 个样本", []float64{42}, 42},
+		{"2 个样本取较大值", []float64{10, 20}, 20},
+		// ceil(19*0.95)=19，样本不足 20 个时不丢弃任何样本
+		{"19 个样本", seq(19), 19},
+		// ceil(20*0.95)=19，丢弃最高的 1 个
+		{"20 个样本", seq(20), 19},
+		{"21 个样本", seq(21), 20},
+		{"100 个样本", seq(100), 95},
+		// 一个 30 天账期的 5 分钟样本，丢弃最高的 432 个
+		{"8640 个样本", seq(8640), 8208},
+		{"相同的值", []float64{5, 5, 5, 5}, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile95(tt.values); got != tt.want {
+				t.Errorf("percentile95 = %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// 命令行子命令
+// 全局参数（-config、-set、-port、-log-level）写在子命令之前，各子命令共用相同的配置加载流程；
+// 不指定子命令时等同于 serve。
+
+// 子命令运行前需要完成的初始化
+const (
+	setupNone    = iota // 不加载配置
+	setupConfig         // 加载配置并初始化日志
+	setupDB             // 另外连接数据库
+	setupMigrate        // 连接数据库后创建缺少的表并执行数据迁移，只用于 serve
+)
+
+// cliCommand 子命令
+type cliCommand struct {
+	name  string
+	args  string
+	usage string
+	setup int
+	run   func(args []string) error
+}
+
+// cliCommands 所有子命令，在 init 中赋值以避免初始化循环
+var cliCommands []cliCommand
+
+func init() {
+	cliCommands = []cliCommand{
+		{"serve", "", "启动采集服务（默认）", setupMigrate, runServe},
+		{"migrate", "", "创建缺少的数据库表和字段后退出", setupConfig, runMigrate},
+		{"rollup", "-from 时间 -to 时间 [-replace=false]", "重新统计指定时间段的网络流量（小时和 5 分钟）", setupDB, runRollup},
+		{"retention", "[-dry-run] [-only disk,cpu]", "按保留策略删除过期数据", setupDB, runRetention},
+		{"replay", "[-precision 单位] <文件|->", "将保存的 Telegraf JSON 数据重新写入数据库", setupDB, runReplay},
+		{"export", "-table 表 [-from 时间] [-to 时间] [-host 主机] [-format csv|json] [-o 文件]", "导出指标数据", setupDB, runExport},
+		{"config", "validate [文件]", "校验配置文件，不连接数据库", setupNone, runConfigCommand},
+		{"silence", "add|list|expire", "管理告警静默", setupDB, runSilenceCommand},
+		{"jobs", "list|run <任务> [-from 时间 -to 时间]|history [-job 任务]", "查看或立即执行任务", setupDB, runJobsCommand},
+	}
+}
+
+// findCommand 按名称查找子命令
+func findCommand(name string) (cliCommand, bool) {
+	for _, c := range cliCommands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return cliCommand{}, false
+}
+
+// cliUsage 输出命令行用法
+func cliUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "用法: %s [全局参数] <子命令> [参数]\n\n子命令:\n", os.Args[0])
+	for _, c := range cliCommands {
+		fmt.Fprintf(out, "  %-10s %s\n", c.name, c.usage)
+		if c.args != "" {
+			fmt.Fprintf(out, "  %-10s   %s %s\n", "", c.name, c.args)
+		}
+	}
+	fmt.Fprintln(out, "\n全局参数:")
+	flag.PrintDefaults()
+}
+
+// runCommand 完成子命令需要的初始化后执行
+func runCommand(args []string) error {
+	name := "serve"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := findCommand(name)
+	if !ok {
+		cliUsage()
+		return fmt.Errorf("未知的子命令: %s", name)
+	}
+
+	if cmd.setup >= setupConfig {
+		if err := LoadConfig(configPath); err != nil {
+			return fmt.Errorf("无法加载配置: %w", err)
+		}
+		if err := initLogging(); err != nil {
+			return fmt.Errorf("无法初始化日志: %w", err)
+		}
+	}
+	if cmd.setup >= setupDB {
+		InitDb(cmd.setup >= setupMigrate)
+	}
+	return cmd.run(args)
+}
+
+// parseCLITime 解析命令行中的时间，支持 RFC3339、"2006-01-02 15:04:05"、"2006-01-02 15:04" 和 "2006-01-02"，
+// 未指定时区时使用本地时间
+func parseCLITime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{time.DateTime, "2006-01-02 15:04", time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("无法解析时间: %q", s)
+}
+
+// runMigrate 创建缺少的数据库表和字段
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := openDb(); err != nil {
+		return err
+	}
+	created, err := migrateDb()
+	for _, table := range created {
+		fmt.Printf("已创建表 %s\n", table)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("数据库表结构已是最新")
+	return nil
+}
+
+// runRollup 重新统计指定时间段的网络流量，时间段按小时对齐
+func runRollup(args []string) error {
+	fs := flag.NewFlagSet("rollup", flag.ContinueOnError)
+	fromStr := fs.String("from", "", "开始时间（包含），如 2024-05-01 或 \"2024-05-01 08:00\"")
+	toStr := fs.String("to", "", "结束时间（不包含）")
+	replace := fs.Bool("replace", false, "先删除该时间段内原始数据覆盖的小时已有的统计结果（默认只覆盖重新统计的结果）")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *fromStr == "" || *toStr == "" {
+		return fmt.Errorf("用法: rollup -from 时间 -to 时间")
+	}
+	from, err := parseCLITime(*fromStr)
+	if err != nil {
+		return err
+	}
+	to, err := parseCLITime(*toStr)
+	if err != nil {
+		return err
+	}
+	// 与 collectDisposeHour 使用同一个任务租约，避免和服务中正在执行的统计重叠
+	lease := findJob("collectDisposeHour").jobLease()
+	ok, err := tryAcquireLease(lease, leaseTTL())
+	if err != nil {
+		return fmt.Errorf("获取任务租约失败: %v", err)
+	}
+	if !ok {
+		return fmt.Errorf("collectDisposeHour %v，请稍后重试", errJobRunning)
+	}
+	var result rollupResult
+	leaseErr := holdLease(lease, leaseTTL(), func(ctx context.Context) {
+		result, err = rollupWindow(ctx, from, to, *replace)
+	})
+	if err == nil {
+		err = leaseErr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s 至 %s: 原始数据 %d 条，写入小时统计 %d 条、5 分钟统计 %d 条\n",
+		result.From.Format(time.DateTime), result.To.Format(time.DateTime), result.Raw, result.Hour, result.FiveMin)
+	return nil
+}
+
+// runRetention 按保留策略删除过期数据
+func runRetention(args []string) error {
+	fs := flag.NewFlagSet("retention", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "只统计将删除的条数，不删除")
+	only := fs.String("only", "", "只处理指定的数据，逗号分隔，可选 disk、cpu、cpu_cores、mem、net_5min、job_runs")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	selected := retentionPolicies
+	if *only != "" {
+		selected = nil
+		for _, name := range strings.Split(*only, ",") {
+			found := false
+			for _, p := range retentionPolicies {
+				if p.name == strings.TrimSpace(name) {
+					selected = append(selected, p)
+					found = true
+				}
+			}
+			if !found {
+				return fmt.Errorf("未知的数据: %s", name)
+			}
+		}
+	}
+
+	now := time.Now()
+	action := "已删除"
+	if *dryRun {
+		action = "将删除"
+	}
+	for _, p := range selected {
+		n, err := p.apply(context.Background(), now, *dryRun)
+		if err != nil {
+			return fmt.Errorf("%s: %v", p.name, err)
+		}
+		fmt.Printf("%-10s 保留 %d 天，%s %s 之前的数据 %d 条\n", p.name, int(p.keep.Hours()/24), action, p.cutoff(now).Format(time.DateTime), n)
+	}
+	return nil
+}
+
+// runReplay 将保存的 Telegraf JSON 数据（如 telegraf_metrics.json）按 /metrics/json 相同的流程写入数据库，
+// 不检查 ingest.max_past 以便重放历史数据
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	precision := fs.String("precision", "", "时间戳单位: s、ms、us、ns、auto，默认使用 ingest.json_precision")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("用法: replay [-precision 单位] <文件|->")
+	}
+	if *precision == "" {
+		*precision = conf().Ingest.JSONPrecision
+	}
+	if *precision == "" {
+		*precision = "auto"
+	}
+	if err := validatePrecision(*precision); err != nil {
+		return err
+	}
+	var (
+		body []byte
+		err  error
+	)
+	if fs.Arg(0) == "-" {
+		body, err = io.ReadAll(os.Stdin)
+	} else {
+		body, err = os.ReadFile(fs.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+	metrics, err := decodeJson(body)
+	if err != nil {
+		return fmt.Errorf("解析 JSON 出错: %v", err)
+	}
+	metrics = normalizeTimestamps("replay", metrics, *precision, time.Now(), false)
+	metrics = filterMetrics("replay", metrics)
+	saveMetrics(metrics)
+	fmt.Printf("已写入 %d 条指标\n", len(metrics))
+	return nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 多实例部署
+// 启用 cluster 后，各实例通过 leases 表中带过期时间的租约选出一个 leader，只有 leader 按计划执行
+// 统计、清理、配额检查等全局任务；心跳保存、告警推进等只处理本实例内存状态的任务仍在每个实例执行。
+// leader 每 lease_ttl/3 续约一次，退出时主动释放租约，异常退出时其他实例在租约过期后接管。
+// 全局任务执行期间另外持有 job:<任务名> 租约（未启用 cluster 时同样持有），手动执行（API 或命令行）在任意实例上都不会与正在执行的同名任务重叠；
+// 续约失败时取消任务，未提交的写入回滚，执行记录为失败。
+// 租约的过期时间由数据库时钟 (NOW(3)) 计算和比较，不依赖各实例的本地时间。
+
+// clusterConfig 多实例部署配置
+type clusterConfig struct {
+	Enable     bool   `json:"enable"`
+	InstanceID string `json:"instance_id"` // 实例标识，默认为 主机名:server_port
+	LeaseTTL   string `json:"lease_ttl"`   // 租约时长，默认 30s
+}
+
+// defaultLeaseTTL 默认租约时长
+const defaultLeaseTTL = 30 * time.Second
+
+// leaderLease leader 租约名称
+const leaderLease = "scheduler"
+
+// Lease 带过期时间的租约，同一时间只有一个持有者
+type Lease struct {
+	Name      string    `gorm:"type:varchar(100);primaryKey" json:"name"` // 租约名称
+	Holder    string    `gorm:"type:varchar(255);not null" json:"holder"` // 持有者（实例标识/进程号）
+	ExpiresAt time.Time `gorm:"not null" json:"expires_at"`               // 过期时间
+	UpdatedAt time.Time `json:"updated_at"`                               // 最近一次获取或续约的时间
+}
+
+// TableName 指定 Lease 的表名
+func (Lease) TableName() string {
+	return "leases"
+}
+
+// leader 当前实例是否持有 leader 租约
+var leader atomic.Bool
+
+// isLeader 当前实例是否应执行全局任务，未启用 cluster 时始终为 true
+func isLeader() bool {
+	return !conf().Cluster.Enable || leader.Load()
+}
+
+// instanceID 实例标识，重启后保持不变，用于任务执行记录
+func instanceID() string {
+	if id := conf().Cluster.InstanceID; id != "" {
+		return id
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return host + ":" + conf().ServerPort
+}
+
+// leaseHolder 租约持有者，同一实例重启后或命令行进程使用不同的持有者
+func leaseHolder() string {
+	return fmt.Sprintf("%s/%d", instanceID(), os.Getpid())
+}
+
+// leaseTTL 解析租约时长配置
+func leaseTTL() time.Duration {
+	if d, err := time.ParseDuration(conf().Cluster.LeaseTTL); err == nil && d > 0 {
+		return d
+	}
+	return defaultLeaseTTL
+}
+
+// errLeaseLost 任务执行期间租约续约失败
+var errLeaseLost = errors.New("租约续约失败，任务已取消")
+
+// dbNowPlus 数据库当前时间加上 d，精确到毫秒
+func dbNowPlus(d time.Duration) clause.Expr {
+	return gorm.Expr("NOW(3) + INTERVAL ? MICROSECOND", d.Microseconds())
+}
+
+// leaseRows 已确认存在的租约记录
+var leaseRows sync.Map
+
+// tryAcquireLease 获取或续约租约，租约由其他持有者持有且未过期时返回 false
+func tryAcquireLease(name string, ttl time.Duration) (bool, error) {
+	if _, ok := leaseRows.Load(name); !ok {
+		// 租约记录不存在时先插入一条已过期的记录
+		err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&Lease{Name: name, ExpiresAt: time.Unix(0, 0)}).Error
+		if err != nil {
+			return false, err
+		}
+		leaseRows.Store(name, struct{}{})
+	}
+	holder := leaseHolder()
+	res := db.Model(&Lease{}).
+		Where("name = ? AND (holder = ? OR expires_at < NOW(3))", name, holder).
+		Updates(map[string]interface{}{"holder": holder, "expires_at": dbNowPlus(ttl)})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// releaseLease 释放当前进程持有的租约
+func releaseLease(name string) error {
+	return db.Model(&Lease{}).
+		Where("name = ? AND holder = ?", name, leaseHolder()).
+		Update("expires_at", dbNowPlus(-time.Second)).Error
+}
+
+// holdLease 在 fn 执行期间每 ttl/3 续约一次。续约失败（租约已被其他持有者获取或数据库出错）时取消 ctx
+// 并返回 errLeaseLost，fn 应使用 ctx 执行数据库操作，使取消后的写入不再提交
+func holdLease(name string, ttl time.Duration, fn func(ctx context.Context)) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	var lost atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if ok, err := tryAcquireLease(name, ttl); err != nil || !ok {
+					cronLog.Error("租约续约失败，取消任务", "lease", name, "err", err)
+					lost.Store(true)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	defer func() {
+		close(done)
+		cancel()
+		if lost.Load() {
+			return
+		}
+		if err := releaseLease(name); err != nil {
+			cronLog.Warn("释放租约失败", "lease", name, "err", err)
+		}
+	}()
+	fn(ctx)
+	if lost.Load() {
+		return errLeaseLost
+	}
+	return nil
+}
+
+// runLeaderElection 定时获取或续约 leader 租约，ctx 结束时停止；未启用 cluster 时直接返回
+func runLeaderElection(ctx context.Context) {
+	if !conf().Cluster.Enable {
+		return
+	}
+	ttl := leaseTTL()
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	var lastRenew time.Time
+	for {
+		ok, err := tryAcquireLease(leaderLease, ttl)
+		switch {
+		case err != nil:
+			cronLog.Warn("获取 leader 租约失败", "err", err)
+			// 无法续约且租约可能已过期时不再视为 leader
+			if leader.Load() && time.Since(lastRenew) >= ttl {
+				leader.Store(false)
+				cronLog.Warn("leader 租约已过期，停止执行全局任务", "instance", instanceID())
+			}
+		case ok:
+			lastRenew = time.Now()
+			if !leader.Swap(true) {
+				cronLog.Info("成为 leader，开始执行全局任务", "instance", instanceID())
+			}
+		default:
+			if leader.Swap(false) {
+				cronLog.Warn("leader 租约已被其他实例获取，停止执行全局任务", "instance", instanceID())
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// resignLeader 退出时释放 leader 租约，其他实例可以立即接管
+func resignLeader() {
+	if !leader.Swap(false) {
+		return
+	}
+	if err := releaseLease(leaderLease); err != nil {
+		cronLog.Warn("释放 leader 租约失败", "err", err)
+		return
+	}
+	cronLog.Info("已释放 leader 租约", "instance", instanceID())
+}
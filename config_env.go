@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// 环境变量和命令行参数覆盖配置
+// 配置按 默认值 -> 配置文件 -> 环境变量 -> 命令行参数 的顺序加载，后者覆盖前者。
+// 每个配置项都可以用 MOC_ 加大写的 JSON 路径设置，如 database.password 对应 MOC_DATABASE_PASSWORD；
+// 加 _FILE 后缀时从文件读取值（如 Docker/Kubernetes secret），切片、map 类型的配置项使用 JSON 文本。
+// 命令行使用 -set database.password=xxx 覆盖任意配置项。
+
+const envPrefix = "MOC_"
+
+// configOverrides 命令行 -set 指定的配置项，重新加载配置时同样生效
+var configOverrides []string
+
+// defaultConfig 配置默认值
+func defaultConfig() *AppConfig {
+	return &AppConfig{
+		ServerPort: "8080",
+		LogLevel:   "info",
+		Database: DatabaseConfig{
+			Host: "127.0.0.1",
+			Port: "3306",
+		},
+	}
+}
+
+// envOrDefault 读取环境变量，未设置时返回默认值
+func envOrDefault(name, def string) string {
+	if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+	return def
+}
+
+// walkConfig 遍历所有配置项，嵌套结构体逐层展开，其余类型作为一个配置项
+func walkConfig(v reflect.Value, prefix []string, fn func(path []string, field reflect.Value) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		path := append(append([]string(nil), prefix...), name)
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := walkConfig(field, path, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(path, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setConfigValue 将字符串转换为配置项的类型并赋值
+func setConfigValue(field reflect.Value, raw string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	default:
+		// 切片、map 等使用 JSON 文本
+		ptr := reflect.New(field.Type())
+		if err := json.Unmarshal([]byte(raw), ptr.Interface()); err != nil {
+			return err
+		}
+		field.Set(ptr.Elem())
+	}
+	return nil
+}
+
+// envName 配置项对应的环境变量名
+func envName(path []string) string {
+	return envPrefix + strings.ToUpper(strings.Join(path, "_"))
+}
+
+// applyEnvOverrides 使用环境变量覆盖配置，返回已应用的环境变量名（不包含值）
+func applyEnvOverrides(c *AppConfig, lookup func(string) (string, bool)) ([]string, error) {
+	var applied []string
+	err := walkConfig(reflect.ValueOf(c).Elem(), nil, func(path []string, field reflect.Value) error {
+		name := envName(path)
+		raw, ok := lookup(name)
+		if !ok {
+			file, ok := lookup(name + "_FILE")
+			if !ok {
+				return nil
+			}
+			b, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("无法读取 %s_FILE: %v", name, err)
+			}
+			raw = strings.TrimRight(string(b), "\r\n")
+			name += "_FILE"
+		}
+		if err := setConfigValue(field, raw); err != nil {
+			return fmt.Errorf("环境变量 %s 无效: %v", name, err)
+		}
+		applied = append(applied, name)
+		return nil
+	})
+	return applied, err
+}
+
+// applyFlagOverrides 使用命令行 -set path=value 覆盖配置
+func applyFlagOverrides(c *AppConfig, overrides []string) error {
+	fields := make(map[string]reflect.Value)
+	_ = walkConfig(reflect.ValueOf(c).Elem(), nil, func(path []string, field reflect.Value) error {
+		fields[strings.Join(path, ".")] = field
+		return nil
+	})
+	for _, o := range overrides {
+		key, raw, ok := strings.Cut(o, "=")
+		if !ok {
+			return fmt.Errorf("无效的 -set 参数 %q，应为 path=value", o)
+		}
+		field, ok := fields[key]
+		if !ok {
+			return fmt.Errorf("未知的配置项: %s", key)
+		}
+		if err := setConfigValue(field, raw); err != nil {
+			return fmt.Errorf("配置项 %s 无效: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// overrideFlag 可重复的 -set 参数
+type overrideFlag []string
+
+func (f *overrideFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *overrideFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
@@ -0,0 +1,532 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// 配置文件格式和校验
+// 按扩展名选择格式：.yaml/.yml 使用 YAML，.toml 使用 TOML，其余按 JSON 解析，三种格式使用相同的字段名。
+// 配置文件中出现未知的配置项时报错，避免拼写错误的配置项被静默忽略；
+// 加载时校验 cron 表达式、端口、主机名等，每个错误都带上配置项路径和所在行号（TOML 只有路径）。
+
+// fieldError 带配置项路径的错误，路径形如 notify.channels[0].smtp.port
+type fieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *fieldError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *fieldError) Unwrap() error {
+	return e.Err
+}
+
+// fieldErrorf 创建带配置项路径的错误
+func fieldErrorf(field, format string, args ...interface{}) error {
+	return &fieldError{Field: field, Err: fmt.Errorf(format, args...)}
+}
+
+// lineError 带行号的解析错误
+type lineError struct {
+	Line int
+	Err  error
+}
+
+func (e *lineError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *lineError) Unwrap() error {
+	return e.Err
+}
+
+// configSource 配置文件路径和各配置项所在的行号
+type configSource struct {
+	path    string
+	lines   map[string]int
+	unknown []error // 未知的配置项，与校验错误一起报告
+}
+
+// unknownKeys 返回所有未知配置项的错误
+func (s *configSource) unknownKeys() error {
+	if s == nil {
+		return nil
+	}
+	return errors.Join(s.unknown...)
+}
+
+// locate 为错误加上配置文件路径和行号，多个错误时逐个处理
+func (s *configSource) locate(err error) error {
+	if err == nil || s == nil || s.path == "" {
+		return err
+	}
+	var located []error
+	for _, e := range flattenErrors(err) {
+		line := 0
+		var le *lineError
+		var fe *fieldError
+		switch {
+		case errors.As(e, &le):
+			line = le.Line
+		case errors.As(e, &fe):
+			line = s.line(fe.Field)
+		}
+		if line > 0 {
+			located = append(located, fmt.Errorf("%s:%d: %w", s.path, line, e))
+		} else {
+			located = append(located, fmt.Errorf("%s: %w", s.path, e))
+		}
+	}
+	return errors.Join(located...)
+}
+
+// line 返回配置项所在的行号，找不到时依次查找上一级配置项
+func (s *configSource) line(field string) int {
+	for field != "" {
+		if n, ok := s.lines[field]; ok {
+			return n
+		}
+		i := strings.LastIndexAny(field, ".[")
+		if i < 0 {
+			break
+		}
+		field = field[:i]
+	}
+	return 0
+}
+
+// flattenErrors 展开 errors.Join 合并的错误
+func flattenErrors(err error) []error {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var errs []error
+		for _, e := range joined.Unwrap() {
+			errs = append(errs, flattenErrors(e)...)
+		}
+		return errs
+	}
+	return []error{err}
+}
+
+// decodeConfigFile 按扩展名解析配置文件到 c，文件中没有的配置项保留原值
+func decodeConfigFile(path string, data []byte, c *AppConfig) (*configSource, error) {
+	src := &configSource{path: path, lines: make(map[string]int)}
+
+	var tree interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var node yaml.Node
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			return src, fmt.Errorf("无法解析 YAML 配置: %v", err)
+		}
+		yamlKeyLines(&node, "", src.lines)
+		if err := node.Decode(&tree); err != nil {
+			return src, fmt.Errorf("无法解析 YAML 配置: %v", err)
+		}
+	case ".toml":
+		if _, err := toml.Decode(string(data), &tree); err != nil {
+			var pe toml.ParseError
+			if errors.As(err, &pe) {
+				return src, &lineError{Line: pe.Position.Line, Err: fmt.Errorf("无法解析 TOML 配置: %s", pe.Message)}
+			}
+			return src, fmt.Errorf("无法解析 TOML 配置: %v", err)
+		}
+	default:
+		if err := json.Unmarshal(data, &tree); err != nil {
+			var se *json.SyntaxError
+			if errors.As(err, &se) {
+				return src, &lineError{Line: lineAt(data, se.Offset), Err: fmt.Errorf("无法解析 JSON 配置: %v", err)}
+			}
+			return src, fmt.Errorf("无法解析 JSON 配置: %v", err)
+		}
+		jsonKeyLines(data, src.lines)
+	}
+	if tree == nil {
+		// 空文件
+		return src, nil
+	}
+
+	// 记录未知的配置项，再统一按 JSON 字段名解码
+	tree, src.unknown = normalizeConfigTree(tree, reflect.TypeOf(AppConfig{}), "")
+	normalized, err := json.Marshal(tree)
+	if err != nil {
+		return src, fmt.Errorf("无法转换配置: %v", err)
+	}
+	if err := json.Unmarshal(normalized, c); err != nil {
+		var te *json.UnmarshalTypeError
+		if errors.As(err, &te) && te.Field != "" {
+			return src, fieldErrorf(te.Field, "类型错误，应为 %s，实际为 %s", te.Type, te.Value)
+		}
+		return src, fmt.Errorf("无法解析配置: %v", err)
+	}
+	return src, nil
+}
+
+// normalizeConfigTree 删除并返回没有对应字段的配置项（与 encoding/json 一样字段名不区分大小写），
+// 并把字符串类型配置项中的数字转换为字符串，YAML、TOML 中的 port: 3306 无需加引号
+func normalizeConfigTree(v interface{}, t reflect.Type, path string) (interface{}, []error) {
+	var errs []error
+	switch t.Kind() {
+	case reflect.Ptr:
+		return normalizeConfigTree(v, t.Elem(), path)
+	case reflect.String:
+		switch n := v.(type) {
+		case int:
+			return strconv.Itoa(n), nil
+		case int64:
+			return strconv.FormatInt(n, 10), nil
+		case float64:
+			return strconv.FormatFloat(n, 'f', -1, 64), nil
+		}
+	case reflect.Struct:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			// 类型不匹配，解码时报告
+			return v, nil
+		}
+		fields := make(map[string]reflect.Type)
+		var names []string
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			fields[name] = t.Field(i).Type
+			names = append(names, name)
+		}
+		for _, key := range sortedKeys(m) {
+			field := joinConfigPath(path, key)
+			ft, ok := fields[key]
+			if !ok {
+				for _, name := range names {
+					if strings.EqualFold(name, key) {
+						ft, ok = fields[name], true
+						break
+					}
+				}
+			}
+			if !ok {
+				if s := suggestKey(key, names); s != "" {
+					errs = append(errs, fieldErrorf(field, "未知的配置项，是否为 %s？", s))
+				} else {
+					errs = append(errs, fieldErrorf(field, "未知的配置项"))
+				}
+				delete(m, key)
+				continue
+			}
+			var sub []error
+			m[key], sub = normalizeConfigTree(m[key], ft, field)
+			errs = append(errs, sub...)
+		}
+	case reflect.Slice, reflect.Array:
+		items, ok := v.([]interface{})
+		if !ok {
+			return v, nil
+		}
+		for i := range items {
+			var sub []error
+			items[i], sub = normalizeConfigTree(items[i], t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+			errs = append(errs, sub...)
+		}
+	case reflect.Map:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return v, nil
+		}
+		for _, key := range sortedKeys(m) {
+			var sub []error
+			m[key], sub = normalizeConfigTree(m[key], t.Elem(), joinConfigPath(path, key))
+			errs = append(errs, sub...)
+		}
+	}
+	return v, errs
+}
+
+// suggestKey 返回与 key 最接近的字段名，忽略大小写和下划线后编辑距离不超过 3 时才返回
+func suggestKey(key string, names []string) string {
+	normalize := func(s string) string {
+		return strings.ReplaceAll(strings.ToLower(s), "_", "")
+	}
+	best, bestDist := "", 4
+	for _, name := range names {
+		if d := editDistance(normalize(key), normalize(name)); d < bestDist {
+			best, bestDist = name, d
+		}
+	}
+	return best
+}
+
+// editDistance 两个字符串的编辑距离
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func joinConfigPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// lineAt 返回偏移量所在的行号
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// jsonKeyLines 记录 JSON 中每个配置项所在的行号
+func jsonKeyLines(data []byte, lines map[string]int) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var walk func(path string) error
+	walk = func(path string) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if _, ok := lines[path]; !ok && path != "" {
+			lines[path] = lineAt(data, dec.InputOffset())
+		}
+		switch tok {
+		case json.Delim('{'):
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				field := joinConfigPath(path, fmt.Sprint(key))
+				lines[field] = lineAt(data, dec.InputOffset())
+				if err := walk(field); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				if err := walk(fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		}
+		return err
+	}
+	_ = walk("")
+}
+
+// yamlKeyLines 记录 YAML 中每个配置项所在的行号
+func yamlKeyLines(node *yaml.Node, path string, lines map[string]int) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, n := range node.Content {
+			yamlKeyLines(n, path, lines)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			field := joinConfigPath(path, node.Content[i].Value)
+			lines[field] = node.Content[i].Line
+			yamlKeyLines(node.Content[i+1], field, lines)
+		}
+	case yaml.SequenceNode:
+		for i, n := range node.Content {
+			field := fmt.Sprintf("%s[%d]", path, i)
+			lines[field] = n.Line
+			yamlKeyLines(n, field, lines)
+		}
+	}
+}
+
+// validatePort 校验端口号
+func validatePort(field, port string) error {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fieldErrorf(field, "端口应为 1-65535 的整数: %q", port)
+	}
+	return nil
+}
+
+// validateHost 校验主机名或 IP 地址
+func validateHost(field, host string) error {
+	if host == "" {
+		return fieldErrorf(field, "不能为空")
+	}
+	if net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return nil
+	}
+	if len(host) > 253 {
+		return fieldErrorf(field, "主机名过长: %q", host)
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return fieldErrorf(field, "无效的主机名: %q", host)
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return fieldErrorf(field, "无效的主机名: %q", host)
+			}
+		}
+	}
+	return nil
+}
+
+// validateConfig 校验配置，返回所有发现的错误
+func validateConfig(c *AppConfig) error {
+	var errs []error
+	add := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	add(validatePort("server_port", c.ServerPort))
+	add(validateHost("database.host", c.Database.Host))
+	add(validatePort("database.port", c.Database.Port))
+	if c.Cron.Enable {
+		if _, err := cron.ParseStandard(c.Cron.ScheduleDispos); err != nil {
+			add(fieldErrorf("cron.schedule_dispose", "无效的 cron 表达式 %q: %v", c.Cron.ScheduleDispos, err))
+		}
+	}
+	for i, r := range c.Alert.Rules {
+		if _, err := parseAlertRule(r); err != nil {
+			add(fieldErrorf(fmt.Sprintf("alert.rules[%d]", i), "告警规则 %q 无效: %v", r.Name, err))
+		}
+	}
+	for i, r := range c.Ingest.Rules {
+		if _, err := parseIngestRule(r); err != nil {
+			add(fieldErrorf(fmt.Sprintf("ingest.rules[%d]", i), "写入规则无效: %v", err))
+		}
+	}
+	for i, w := range c.Maintenance {
+		if _, err := parseMaintenanceWindow(w); err != nil {
+			add(fieldErrorf(fmt.Sprintf("maintenance[%d]", i), "维护窗口 %q 无效: %v", w.Name, err))
+		}
+	}
+	if _, err := newNotifyDispatcher(c.Notify); err != nil {
+		add(&fieldError{Field: "notify", Err: err})
+	}
+	for i, ch := range c.Notify.Channels {
+		if ch.Type != "smtp" {
+			continue
+		}
+		field := fmt.Sprintf("notify.channels[%d].smtp", i)
+		add(validateHost(field+".host", ch.SMTP.Host))
+		add(validatePort(field+".port", ch.SMTP.Port))
+	}
+	if c.TLS.Enable {
+		if _, err := parseTLSVersion(c.TLS.MinVersion); err != nil {
+			add(&fieldError{Field: "tls.min_version", Err: err})
+		}
+		if _, err := parseCipherSuites(c.TLS.CipherSuites, c.TLS.InsecureCipher); err != nil {
+			add(&fieldError{Field: "tls.cipher_suites", Err: err})
+		}
+		if _, err := parseClientAuth(c.TLS.ClientAuth); err != nil {
+			add(&fieldError{Field: "tls.client_auth", Err: err})
+		}
+	}
+	switch c.Log.Format {
+	case "", "text", "json":
+	default:
+		add(fieldErrorf("log.format", "不支持的日志格式: %q", c.Log.Format))
+	}
+	durations := []struct{ field, value string }{
+		{"log.sample_interval", c.Log.SampleInterval},
+		{"self_metrics.store_interval", c.SelfMetrics.StoreInterval},
+		{"shutdown_timeout", c.ShutdownTimeout},
+		{"cluster.lease_ttl", c.Cluster.LeaseTTL},
+		{"ingest.max_future", c.Ingest.MaxFuture},
+		{"ingest.max_past", c.Ingest.MaxPast},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(d.value); err != nil {
+			add(fieldErrorf(d.field, "无效的时间间隔: %v", err))
+		}
+	}
+	if err := validateCPUConfig(c.CPU); err != nil {
+		add(err)
+	}
+	precisions := []struct{ field, value string }{
+		{"ingest.json_precision", c.Ingest.JSONPrecision},
+		{"ingest.lineprotocol_precision", c.Ingest.LineProtocolPrecision},
+	}
+	for _, p := range precisions {
+		if p.value == "" {
+			continue
+		}
+		if err := validatePrecision(p.value); err != nil {
+			add(&fieldError{Field: p.field, Err: err})
+		}
+	}
+	if d, err := time.ParseDuration(c.Cluster.LeaseTTL); err == nil && d < 3*time.Second {
+		add(fieldErrorf("cluster.lease_ttl", "租约时长不能小于 3s"))
+	}
+	return errors.Join(errs...)
+}
+
+// runConfigCommand 处理 config 子命令，不连接数据库
+//
+//	config validate [配置文件]
+func runConfigCommand(args []string) error {
+	if len(args) == 0 || args[0] != "validate" {
+		return fmt.Errorf("用法: config validate [配置文件]")
+	}
+	path := configPath
+	if len(args) > 1 {
+		path = args[1]
+	}
+	if _, err := buildConfig(path); err != nil {
+		errs := flattenErrors(err)
+		for _, e := range errs {
+			fmt.Println(e)
+		}
+		return fmt.Errorf("配置无效，共 %d 个错误", len(errs))
+	}
+	fmt.Printf("配置有效: %s\n", path)
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+)
+
+// 配置热加载
+// 收到 SIGHUP 或配置文件修改时间变化后重新读取配置，校验通过才整体替换当前配置，
+// 然后重新应用日志、告警规则、维护窗口、通知渠道，并重新注册定时任务。
+// 监听端口、数据库连接和 TLS 设置只在启动时使用，修改后保留旧值并提示需要重启。
+
+// configWatchInterval 检查配置文件修改时间的间隔
+const configWatchInterval = 5 * time.Second
+
+// restartRequired 比较只在启动时生效的设置，返回有变化的设置名称，并在 next 中保留 prev 的值
+func restartRequired(prev, next *AppConfig) []string {
+	var changed []string
+	if prev.ServerPort != next.ServerPort {
+		changed = append(changed, "server_port")
+		next.ServerPort = prev.ServerPort
+	}
+	if prev.Database != next.Database {
+		changed = append(changed, "database")
+		next.Database = prev.Database
+	}
+	if !reflect.DeepEqual(prev.TLS, next.TLS) {
+		changed = append(changed, "tls")
+		next.TLS = prev.TLS
+	}
+	if prev.Cluster != next.Cluster {
+		changed = append(changed, "cluster")
+		next.Cluster = prev.Cluster
+	}
+	return changed
+}
+
+// reloadConfig 重新读取配置文件，校验通过后替换当前配置并重新应用
+func reloadConfig() error {
+	next, err := buildConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("新配置校验失败，继续使用当前配置: %w", err)
+	}
+	restart := restartRequired(conf(), next)
+	config.Store(next)
+
+	// 校验已通过，以下步骤失败时只记录日志，其余设置继续生效
+	if err := initLogging(); err != nil {
+		serverLog.Error("重新加载日志配置失败", "err", err)
+	}
+	if rules, err := parseAlertRules(next.Alert.Rules); err == nil {
+		alerts.setRules(rules)
+	}
+	if err := initSilences(); err != nil {
+		serverLog.Error("重新加载维护窗口失败", "err", err)
+	}
+	if err := initNotify(); err != nil {
+		serverLog.Error("重新加载通知渠道失败", "err", err)
+	}
+	rescheduleJobs()
+
+	if len(restart) > 0 {
+		serverLog.Warn("部分设置需要重启后生效", "settings", restart)
+	}
+	serverLog.Info("配置已重新加载", "path", configPath)
+	return nil
+}
+
+// rescheduleJobs 按新配置重新注册定时任务，旧调度器中正在执行的任务会继续执行完
+func rescheduleJobs() {
+	if old := scheduler.Swap(TaskRun()); old != nil {
+		old.Stop()
+	}
+}
+
+// watchConfig 收到 SIGHUP 或配置文件修改后重新加载配置，ctx 结束时停止
+func watchConfig(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	modTime := func() time.Time {
+		if configPath == "" {
+			return time.Time{}
+		}
+		info, err := os.Stat(configPath)
+		if err != nil {
+			return time.Time{}
+		}
+		return info.ModTime()
+	}
+	last := modTime()
+	ticker := time.NewTicker(configWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			serverLog.Info("收到 SIGHUP，重新加载配置")
+		case <-ticker.C:
+			mt := modTime()
+			if mt.IsZero() || mt.Equal(last) {
+				continue
+			}
+			serverLog.Info("配置文件已修改，重新加载配置")
+		}
+		last = modTime()
+		if err := reloadConfig(); err != nil {
+			serverLog.Error("重新加载配置失败", "err", err)
+		}
+	}
+}
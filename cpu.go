@@ -2,7 +2,6 @@ package main
 
 import (
 	"encoding/json"
-	"log"
 )
 
 // CPUFields 表示 CPU 使用情况统计
@@ -25,10 +24,10 @@ type CPUFields struct {
 // CPUFieldsDb 用于数据库存储的 CPU 字段结构体
 // 使用 gorm 标签定义数据库字段映射
 type CPUFieldsDb struct {
-	ID        uint   `gorm:"primaryKey;autoIncrement" json:"id"`           // 主键ID，自增
-	CPU       string `gorm:"type:varchar(50);not null;index" json:"cpu"`   // CPU 标识符，如 "cpu0", "cpu1", "cpu-total"
-	Host      string `gorm:"type:varchar(100);not null;index" json:"host"` // 主机名
-	Timestamp int64  `gorm:"not null;index" json:"timestamp"`              // 时间戳（纳秒或毫秒）
+	ID        uint   `gorm:"primaryKey;autoIncrement" json:"id"`                                                 // 主键ID，自增
+	CPU       string `gorm:"type:varchar(50);not null;index;uniqueIndex:idx_cpu_sample,priority:2" json:"cpu"`   // CPU 标识符，如 "cpu0", "cpu1", "cpu-total"
+	Host      string `gorm:"type:varchar(100);not null;index;uniqueIndex:idx_cpu_sample,priority:1" json:"host"` // 主机名
+	Timestamp int64  `gorm:"not null;index;uniqueIndex:idx_cpu_sample,priority:3" json:"timestamp"`              // 时间戳（秒）
 
 	// CPU 使用情况字段
 	UsageActive    float64 `gorm:"type:decimal(10,6);not null" json:"usage_active"`     // CPU 活跃时间百分比
@@ -79,12 +78,13 @@ func (c *CPUFields) FromFieldsMap(m map[string]interface{}) error {
 	return json.Unmarshal(b, c)
 }
 
-// SaveCPUToDB 保存 CPU 数据到数据库
-func SaveCPUToDB(metric *TelegrafJson) {
+// SaveCPUToDB 保存 CPU 数据到数据库，返回是否为已保存过的重复数据
+func SaveCPUToDB(metric *TelegrafJson) bool {
 	var cpuFields CPUFields
 	if err := cpuFields.FromFieldsMap(metric.Fields); err != nil {
-		log.Printf("解析 CPU 字段出错: %v", err)
-		return
+		fieldErrors.inc("cpu")
+		storeLog.Warn("解析 CPU 字段出错", "host", metric.Tags["host"], "err", err)
+		return false
 	}
 	// 转换为数据库实体
 	var cpuDb CPUFieldsDb
@@ -94,10 +94,14 @@ func SaveCPUToDB(metric *TelegrafJson) {
 		metric.Timestamp,    // 时间戳
 		cpuFields,           // CPU 指标
 	)
-	// 保存到数据库
-	if err := db.Create(&cpuDb).Error; err != nil {
-		log.Printf("保存 CPU 数据到数据库出错: %v", err)
-		return
+	// 按存储策略汇总每核数据，只保存需要的原始数据
+	if !cpuCores.observe(cpuDb.Host, cpuDb.CPU, cpuDb.Timestamp, cpuDb.UsageActive) {
+		return false
 	}
-
+	// 保存到数据库，重复的数据会被忽略
+	duplicate, err := insertSample("cpu", &cpuDb)
+	if err != nil {
+		storeLog.Error("保存 CPU 数据到数据库出错", "err", err)
+	}
+	return duplicate
 }
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CPU 存储策略和拓扑
+// cpu.store 控制 cpu_metrics 保存哪些数据：full（默认，保存全部）、total（只保存 cpu-total）、
+// downsample（cpu-total 保存原始数据，每核只保存汇总）。full 和 downsample 下每核 usage_active 按 cpu.core_interval
+// 汇总到 cpu_core_rollups，供 /api/cpu/heatmap 查询；汇总在内存中累加，每分钟合并写入数据库。
+// 每个主机同一时间戳上报的核数变化时在 cpu_topologies 中新增一条记录（随每核汇总写入），/api/cpu/topology 查询变化历史。
+// 写入失败的汇总和拓扑变化留在内存中，下次写入时重试。
+
+// CPU 存储策略
+const (
+	cpuStoreFull       = "full"
+	cpuStoreTotal      = "total"
+	cpuStoreDownsample = "downsample"
+)
+
+// cpuTotal Telegraf 汇总全部核心的 cpu 标签
+const cpuTotal = "cpu-total"
+
+// defaultCoreInterval 默认每核汇总间隔
+const defaultCoreInterval = 5 * time.Minute
+
+// cpuConfig CPU 数据配置
+type cpuConfig struct {
+	Store        string `json:"store"`         // full（默认）、total、downsample
+	CoreInterval string `json:"core_interval"` // 每核数据汇总间隔，默认 5m，需能整除 1 小时
+}
+
+// cpuStorePolicy 当前的 CPU 存储策略
+func cpuStorePolicy() string {
+	if s := conf().CPU.Store; s != "" {
+		return s
+	}
+	return cpuStoreFull
+}
+
+// coreInterval 解析每核汇总间隔配置
+func coreInterval() time.Duration {
+	return durationOrDefault(conf().CPU.CoreInterval, defaultCoreInterval)
+}
+
+// validateCPUConfig 校验 CPU 数据配置
+func validateCPUConfig(c cpuConfig) error {
+	switch c.Store {
+	case "", cpuStoreFull, cpuStoreTotal, cpuStoreDownsample:
+	default:
+		return fieldErrorf("cpu.store", "不支持的存储策略: %q，可选 full、total、downsample", c.Store)
+	}
+	if c.CoreInterval == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(c.CoreInterval)
+	if err != nil {
+		return fieldErrorf("cpu.core_interval", "无效的时间间隔: %v", err)
+	}
+	if d < time.Minute || time.Hour%d != 0 {
+		return fieldErrorf("cpu.core_interval", "汇总间隔需不小于 1m 且能整除 1 小时")
+	}
+	return nil
+}
+
+// CPUCoreRollup 每核 CPU 使用率汇总
+type CPUCoreRollup struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`                                            // 数据库主键
+	Host      string    `gorm:"type:varchar(100);not null;uniqueIndex:idx_core_bucket,priority:1" json:"host"` // 主机名
+	CPU       string    `gorm:"type:varchar(50);not null;uniqueIndex:idx_core_bucket,priority:3" json:"cpu"`   // CPU 标识，如 cpu0
+	Bucket    time.Time `gorm:"not null;index;uniqueIndex:idx_core_bucket,priority:2" json:"bucket"`           // 时间段起点
+	UsageSum  float64   `gorm:"not null" json:"usage_sum"`                                                     // 时间段内 usage_active 之和
+	UsageMax  float64   `gorm:"type:decimal(10,6);not null" json:"usage_max"`                                  // 时间段内 usage_active 最大值
+	Samples   int64     `gorm:"not null" json:"samples"`                                                       // 时间段内样本数
+	UpdatedAt time.Time `json:"updated_at"`                                                                    // 记录更新时间
+}
+
+// TableName 指定 CPUCoreRollup 的表名
+func (CPUCoreRollup) TableName() string {
+	return "cpu_core_rollups"
+}
+
+// CPUTopology 主机 CPU 核数，核数变化时新增一条记录
+type CPUTopology struct {
+	ID    uint       `gorm:"primaryKey;autoIncrement" json:"id"`           // 数据库主键
+	Host  string     `gorm:"type:varchar(100);not null;index" json:"host"` // 主机名
+	Cores int        `gorm:"not null" json:"cores"`                        // 核数
+	Since time.Time  `gorm:"not null" json:"since"`                        // 首次上报该核数的时间
+	Until *time.Time `json:"until,omitempty"`                              // 核数变化的时间，为空表示当前拓扑
+}
+
+// TableName 指定 CPUTopology 的表名
+func (CPUTopology) TableName() string {
+	return "cpu_topologies"
+}
+
+// coreKey 每核汇总的键
+type coreKey struct {
+	host   string
+	cpu    string
+	bucket time.Time
+}
+
+// coreAgg 内存中尚未写入数据库的每核汇总
+type coreAgg struct {
+	sum     float64
+	max     float64
+	samples int64
+}
+
+// topologyChange 尚未写入数据库的核数变化
+type topologyChange struct {
+	cores int
+	at    time.Time
+}
+
+// hostCores 主机当前时间戳已上报的核心和尚未写入的核数变化
+type hostCores struct {
+	timestamp int64
+	cores     map[string]bool
+	observed  int // 最近一个完整时间戳的核数
+	changes   []topologyChange
+}
+
+// cpuTracker 记录每核汇总和主机拓扑
+type cpuTracker struct {
+	mu       sync.Mutex
+	pending  map[coreKey]*coreAgg
+	lastSeen map[string]int64 // host/cpu 最近一次计入汇总的时间戳，用于忽略重复发送的数据
+	hosts    map[string]*hostCores
+
+	// 数据库中的当前拓扑，只在写入时使用，不占用 mu
+	topoMu     sync.Mutex
+	topologies map[string]*CPUTopology // 值为 nil 表示该主机还没有记录
+}
+
+var cpuCores = &cpuTracker{
+	pending:    make(map[coreKey]*coreAgg),
+	lastSeen:   make(map[string]int64),
+	hosts:      make(map[string]*hostCores),
+	topologies: make(map[string]*CPUTopology),
+}
+
+// observe 记录一条 CPU 数据，返回是否需要保存到 cpu_metrics
+func (t *cpuTracker) observe(host, cpu string, timestamp int64, usageActive float64) bool {
+	if host == "" || cpu == "" {
+		return true
+	}
+	policy := cpuStorePolicy()
+	if cpu == cpuTotal {
+		return true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.trackCoreLocked(host, cpu, timestamp)
+	if policy == cpuStoreTotal {
+		return false
+	}
+
+	seenKey := host + "/" + cpu
+	if timestamp > t.lastSeen[seenKey] {
+		t.lastSeen[seenKey] = timestamp
+		key := coreKey{host: host, cpu: cpu, bucket: bucketStart(time.Unix(timestamp, 0), coreInterval())}
+		agg, ok := t.pending[key]
+		if !ok {
+			agg = &coreAgg{}
+			t.pending[key] = agg
+		}
+		agg.sum += usageActive
+		agg.max = max(agg.max, usageActive)
+		agg.samples++
+	}
+	return policy == cpuStoreFull
+}
+
+// merge 合并另一份汇总
+func (a *coreAgg) merge(b *coreAgg) {
+	a.sum += b.sum
+	a.max = max(a.max, b.max)
+	a.samples += b.samples
+}
+
+// trackCoreLocked 按时间戳统计主机上报的核心，时间戳前进时比较上一时间戳的核数，核数变化时记录下来等待写入，调用方需持有锁
+func (t *cpuTracker) trackCoreLocked(host, cpu string, timestamp int64) {
+	h, ok := t.hosts[host]
+	if !ok {
+		h = &hostCores{cores: make(map[string]bool)}
+		t.hosts[host] = h
+	}
+	switch {
+	case timestamp == h.timestamp:
+		h.cores[cpu] = true
+	case timestamp > h.timestamp:
+		if n := len(h.cores); n > 0 && n != h.observed {
+			h.observed = n
+			h.changes = append(h.changes, topologyChange{cores: n, at: time.Unix(h.timestamp, 0)})
+		}
+		h.timestamp = timestamp
+		h.cores = map[string]bool{cpu: true}
+	}
+	// 更早的时间戳为重发或乱序数据，不影响拓扑
+}
+
+// persistTopology 依次写入主机的核数变化，与当前拓扑不同时结束当前记录并新增一条，返回已处理的条数
+func (t *cpuTracker) persistTopology(host string, changes []topologyChange) (int, error) {
+	t.topoMu.Lock()
+	defer t.topoMu.Unlock()
+
+	current, loaded := t.topologies[host]
+	if !loaded {
+		var rows []CPUTopology
+		if err := db.Where("host = ? AND until IS NULL", host).Order("id DESC").Limit(1).Find(&rows).Error; err != nil {
+			return 0, fmt.Errorf("读取 CPU 拓扑失败: %v", err)
+		}
+		if len(rows) > 0 {
+			current = &rows[0]
+		}
+		t.topologies[host] = current
+	}
+
+	for i, c := range changes {
+		if current != nil && current.Cores == c.cores {
+			continue
+		}
+		next := &CPUTopology{Host: host, Cores: c.cores, Since: c.at}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if current != nil {
+				if err := tx.Model(current).Update("until", c.at).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Create(next).Error
+		})
+		if err != nil {
+			return i, fmt.Errorf("保存 CPU 拓扑失败: %v", err)
+		}
+		if current != nil {
+			storeLog.Info("主机 CPU 核数变化", "host", host, "from", current.Cores, "to", c.cores)
+		}
+		current = next
+		t.topologies[host] = current
+	}
+	return len(changes), nil
+}
+
+// flushCPUCores 将内存中的每核汇总合并写入数据库，多个实例写入同一时间段时累加；同时写入主机核数变化。
+// 写入失败的汇总和核数变化放回内存，下次重试
+func flushCPUCores() error {
+	cpuCores.mu.Lock()
+	pending := cpuCores.pending
+	cpuCores.pending = make(map[coreKey]*coreAgg)
+	changes := make(map[string][]topologyChange)
+	for host, h := range cpuCores.hosts {
+		if len(h.changes) > 0 {
+			changes[host] = h.changes
+			h.changes = nil
+		}
+	}
+	cpuCores.mu.Unlock()
+
+	var firstErr error
+	failed := make(map[coreKey]*coreAgg)
+	for key, agg := range pending {
+		row := CPUCoreRollup{Host: key.host, CPU: key.cpu, Bucket: key.bucket, UsageSum: agg.sum, UsageMax: agg.max, Samples: agg.samples}
+		err := db.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "host"}, {Name: "bucket"}, {Name: "cpu"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"usage_sum":  gorm.Expr("usage_sum + ?", agg.sum),
+				"usage_max":  gorm.Expr("CASE WHEN usage_max < ? THEN ? ELSE usage_max END", agg.max, agg.max),
+				"samples":    gorm.Expr("samples + ?", agg.samples),
+				"updated_at": time.Now(),
+			}),
+		}).Create(&row).Error
+		if err != nil {
+			failed[key] = agg
+			if firstErr == nil {
+				firstErr = fmt.Errorf("保存每核 CPU 汇总失败: %v", err)
+			}
+		}
+	}
+
+	requeue := make(map[string][]topologyChange)
+	for host, list := range changes {
+		n, err := cpuCores.persistTopology(host, list)
+		if err != nil {
+			requeue[host] = list[n:]
+			storeLog.Warn("保存 CPU 拓扑失败", "host", host, "err", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	if len(failed) > 0 || len(requeue) > 0 {
+		cpuCores.mu.Lock()
+		for key, agg := range failed {
+			if cur, ok := cpuCores.pending[key]; ok {
+				agg.merge(cur)
+			}
+			cpuCores.pending[key] = agg
+		}
+		for host, list := range requeue {
+			if h, ok := cpuCores.hosts[host]; ok {
+				h.changes = append(list, h.changes...)
+			}
+		}
+		cpuCores.mu.Unlock()
+	}
+	return firstErr
+}
+
+// cpuCoreIndex 返回 cpuN 中的 N，用于按核心编号排序
+func cpuCoreIndex(cpu string) int {
+	n, err := strconv.Atoi(strings.TrimPrefix(cpu, "cpu"))
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// cpuHeatmap /api/cpu/heatmap 的响应，Values[i][j] 为 CPUs[i] 在 Buckets[j] 的平均 usage_active，没有数据时为 null
+type cpuHeatmap struct {
+	Host     string       `json:"host"`
+	Interval string       `json:"interval"`
+	CPUs     []string     `json:"cpus"`
+	Buckets  []time.Time  `json:"buckets"`
+	Values   [][]*float64 `json:"values"`
+}
+
+// buildCPUHeatmap 按 interval 合并每核汇总
+func buildCPUHeatmap(host string, rows []CPUCoreRollup, from, to time.Time, interval time.Duration) cpuHeatmap {
+	type cell struct {
+		sum     float64
+		samples int64
+	}
+	cells := make(map[string]map[int64]*cell) // 按 Unix 时间索引，避免时区不同的同一时间不相等
+	for _, r := range rows {
+		bucket := bucketStart(r.Bucket, interval).Unix()
+		byBucket, ok := cells[r.CPU]
+		if !ok {
+			byBucket = make(map[int64]*cell)
+			cells[r.CPU] = byBucket
+		}
+		c, ok := byBucket[bucket]
+		if !ok {
+			c = &cell{}
+			byBucket[bucket] = c
+		}
+		c.sum += r.UsageSum
+		c.samples += r.Samples
+	}
+
+	heatmap := cpuHeatmap{Host: host, Interval: interval.String(), CPUs: []string{}, Buckets: []time.Time{}}
+	for cpu := range cells {
+		heatmap.CPUs = append(heatmap.CPUs, cpu)
+	}
+	sort.Slice(heatmap.CPUs, func(i, j int) bool {
+		a, b := cpuCoreIndex(heatmap.CPUs[i]), cpuCoreIndex(heatmap.CPUs[j])
+		if a != b {
+			return a < b
+		}
+		return heatmap.CPUs[i] < heatmap.CPUs[j]
+	})
+	for b := bucketStart(from, interval); b.Before(to); b = b.Add(interval) {
+		heatmap.Buckets = append(heatmap.Buckets, b)
+	}
+	for _, cpu := range heatmap.CPUs {
+		values := make([]*float64, len(heatmap.Buckets))
+		for j, b := range heatmap.Buckets {
+			if c, ok := cells[cpu][b.Unix()]; ok && c.samples > 0 {
+				avg := c.sum / float64(c.samples)
+				values[j] = &avg
+			}
+		}
+		heatmap.Values = append(heatmap.Values, values)
+	}
+	return heatmap
+}
+
+// handleCPUHeatmap 查询主机每核 CPU 使用率热力图，参数: host（必填）、from、to、interval（默认 cpu.core_interval）
+func handleCPUHeatmap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "只接受 GET 请求", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	host := query.Get("host")
+	if host == "" {
+		http.Error(w, "缺少 host 参数", http.StatusBadRequest)
+		return
+	}
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	interval := coreInterval()
+	if s := query.Get("interval"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < interval || d%interval != 0 || time.Hour%d != 0 {
+			http.Error(w, fmt.Sprintf("interval 需为 %s 的整数倍且能整除 1 小时", interval), http.StatusBadRequest)
+			return
+		}
+		interval = d
+	}
+	if to.Sub(from)/interval > 2000 {
+		http.Error(w, "时间段过长，请增大 interval", http.StatusBadRequest)
+		return
+	}
+
+	var rows []CPUCoreRollup
+	err = db.Where("host = ? AND bucket >= ? AND bucket < ?", host, bucketStart(from, interval), to).Find(&rows).Error
+	if err != nil {
+		http.Error(w, "查询每核 CPU 数据失败", http.StatusInternalServerError)
+		apiLog.Error("查询每核 CPU 数据失败", "host", host, "err", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, buildCPUHeatmap(host, rows, from, to, interval))
+}
+
+// handleCPUTopology 查询主机 CPU 核数变化历史，参数: host
+func handleCPUTopology(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "只接受 GET 请求", http.StatusMethodNotAllowed)
+		return
+	}
+	tx := db.Order("host, since")
+	if host := r.URL.Query().Get("host"); host != "" {
+		tx = tx.Where("host = ?", host)
+	}
+	var result []CPUTopology
+	if err := tx.Find(&result).Error; err != nil {
+		http.Error(w, "查询 CPU 拓扑失败", http.StatusInternalServerError)
+		apiLog.Error("查询 CPU 拓扑失败", "err", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
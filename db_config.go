@@ -1,12 +1,12 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"gorm.io/driver/mysql"
@@ -28,91 +28,271 @@ type cronConfig struct {
 }
 
 type AppConfig struct {
-	ServerPort string         `json:"server_port"`
-	Database   DatabaseConfig `json:"database"`
-	LogLevel   string         `json:"log_level"`
-	Cron       cronConfig     `json:"cron"`
+	ServerPort      string                    `json:"server_port"`
+	Database        DatabaseConfig            `json:"database"`
+	LogLevel        string                    `json:"log_level"`
+	Cron            cronConfig                `json:"cron"`
+	Quotas          []quotaConfig             `json:"quotas"`
+	Alert           alertConfig               `json:"alert"`
+	Heartbeat       heartbeatConfig           `json:"heartbeat"`
+	Notify          notifyConfig              `json:"notify"`
+	Forecast        forecastConfig            `json:"forecast"`
+	Anomaly         anomalyConfig             `json:"anomaly"`
+	Maintenance     []maintenanceWindowConfig `json:"maintenance"`
+	Auth            authConfig                `json:"auth"`
+	TLS             tlsConfig                 `json:"tls"`
+	SelfMetrics     selfMetricsConfig         `json:"self_metrics"`
+	Log             logConfig                 `json:"log"`
+	Cluster         clusterConfig             `json:"cluster"`
+	Ingest          ingestConfig              `json:"ingest"`
+	CPU             cpuConfig                 `json:"cpu"`
+	ShutdownTimeout string                    `json:"shutdown_timeout"` // 退出时等待请求和任务完成的最长时间，默认 10s
 }
 
 // 全局变量，用于存储加载的配置
 var (
-	db     *gorm.DB
-	config AppConfig
+	db         *gorm.DB
+	config     atomic.Pointer[AppConfig]
+	configPath string
 )
 
+// conf 返回当前配置，重新加载配置时整体替换，调用方不要修改返回值
+func conf() *AppConfig {
+	return config.Load()
+}
+
+// LoadConfig 按默认值、配置文件、环境变量、命令行参数的顺序加载配置，校验通过后替换当前配置
+// path 为空时不读取配置文件
 func LoadConfig(path string) error {
+	c, err := buildConfig(path)
+	if err != nil {
+		return err
+	}
+	config.Store(c)
+	configPath = path
+
+	serverLog.Info("配置加载成功", "path", path)
+	return nil
+}
+
+// buildConfig 依次应用默认值、配置文件、环境变量和命令行参数，并校验结果
+func buildConfig(path string) (*AppConfig, error) {
+	c := defaultConfig()
+	var src *configSource
+	if path != "" {
+		var err error
+		if src, err = readConfigFile(path, c); err != nil {
+			return nil, src.locate(err)
+		}
+	}
+	applied, err := applyEnvOverrides(c, os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	if len(applied) > 0 {
+		serverLog.Info("已应用环境变量覆盖", "vars", applied)
+	}
+	if err := applyFlagOverrides(c, configOverrides); err != nil {
+		return nil, err
+	}
+	if err := errors.Join(src.unknownKeys(), validateConfig(c)); err != nil {
+		return nil, src.locate(err)
+	}
+	return c, nil
+}
+
+// readConfigFile 读取配置文件，文件中没有的配置项保留原值
+func readConfigFile(path string, c *AppConfig) (*configSource, error) {
 	// 打开配置文件
 	configFile, err := os.Open(path)
 	if err != nil {
-		return fmt.Errorf("无法打开配置文件 %s: %v", path, err)
+		return nil, fmt.Errorf("无法打开配置文件 %s: %v", path, err)
 	}
 	defer configFile.Close()
 
 	// 读取文件内容
 	bytes, err := io.ReadAll(configFile)
 	if err != nil {
-		return fmt.Errorf("无法读取配置文件: %v", err)
+		return nil, fmt.Errorf("无法读取配置文件: %v", err)
 	}
 
-	// 使用 'encoding/json' 将文件内容解析(Unmarshal)到 AppConfig 结构体中
-	err = json.Unmarshal(bytes, &config)
-	if err != nil {
-		return fmt.Errorf("无法解析 JSON 配置: %v", err)
-	}
+	// 按扩展名解析为 JSON、YAML 或 TOML
+	return decodeConfigFile(path, bytes, c)
+}
 
-	log.Println("配置加载成功。")
-	return nil
+// dbModels 需要创建的数据库表
+var dbModels = []interface{}{
+	&CPUFieldsDb{},
+	&DiskFieldsDb{},
+	&MemFieldsDb{},
+	&NetInterfaceFieldsDb{},
+	&NetInterfaceCollectHour{},
+	&NetInterfaceCollect5Min{},
+	&TrafficQuotaStatus{},
+	&Alert{},
+	&AlertHistory{},
+	&HostHeartbeat{},
+	&NotificationDelivery{},
+	&DiskForecast{},
+	&AnomalyEvent{},
+	&Silence{},
+	&SelfMetric{},
+	&JobRun{},
+	&Lease{},
+	&SchemaMigration{},
+	&CPUCoreRollup{},
+	&CPUTopology{},
 }
 
-func InitDb() {
+// InitDb 连接数据库，migrate 为 true 时创建缺少的表并执行数据迁移
+// 数据迁移会删除重复数据，只在 serve 和 migrate 中执行，其他子命令只连接数据库
+func InitDb(migrate bool) {
+	if err := openDb(); err != nil {
+		fatal(storeLog, "无法连接到数据库", "err", err)
+	}
+	if migrate {
+		if _, err := migrateDb(); err != nil {
+			fatal(storeLog, "数据库迁移失败", "err", err)
+		}
+	}
+	storeLog.Info("成功连接到 MySQL 数据库", "user", conf().Database.User, "host", conf().Database.Host)
+}
+
+// openDb 连接数据库并设置连接池
+func openDb() error {
 	// 从 'config' 变量动态构建 DSN，而不是硬编码
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		config.Database.User,
-		config.Database.Password,
-		config.Database.Host,
-		config.Database.Port,
-		config.Database.DBName,
+		conf().Database.User,
+		conf().Database.Password,
+		conf().Database.Host,
+		conf().Database.Port,
+		conf().Database.DBName,
 	)
 	cfg := &gorm.Config{
-		Logger: logger.New(
-			log.New(os.Stdout, "\r\n", log.LstdFlags),
-			logger.Config{
-				SlowThreshold: 200 * time.Millisecond,
-				LogLevel:      parseLogLevel(config.LogLevel),
-			},
-		),
+		Logger: &gormLogger{
+			level:         parseLogLevel(conf().LogLevel),
+			slowThreshold: 200 * time.Millisecond,
+		},
 	}
 	var err error
 	db, err = gorm.Open(mysql.Open(dsn), cfg)
 	if err != nil {
-		log.Fatalf("无法连接到数据库: %v", err)
+		return err
 	}
-	// 首次启动时创建表结构，避免每次启动都执行迁移
-	models := []interface{}{
-		&CPUFieldsDb{},
-		&DiskFieldsDb{},
-		&MemFieldsDb{},
-		&NetInterfaceFieldsDb{},
-		&NetInterfaceCollectHour{},
-	}
-	migrator := db.Migrator()
-	for _, model := range models {
-		if !migrator.HasTable(model) {
-			if err := migrator.CreateTable(model); err != nil {
-				log.Fatalf("创建数据库表失败: %v", err)
-			}
-		}
+	if err := registerDBMetrics(db); err != nil {
+		return fmt.Errorf("无法注册数据库指标回调: %v", err)
 	}
 	sqlDB, err := db.DB()
 	if err != nil {
-		log.Fatalf("无法从 GORM 获取 sql.DB: %v", err)
+		return fmt.Errorf("无法从 GORM 获取 sql.DB: %v", err)
 	}
 
 	sqlDB.SetMaxOpenConns(25)
 	sqlDB.SetMaxIdleConns(25)
 	sqlDB.SetConnMaxLifetime(5 * time.Minute)
+	return nil
+}
 
-	log.Printf("成功连接到 MySQL 数据库 (%s@%s)!", config.Database.User, config.Database.Host)
+// migrateDb 创建缺少的表、字段和索引并执行数据迁移，返回新创建的表名
+// 首次启动时创建表结构，之后只补充缺少的表和字段，避免每次启动都执行完整迁移
+func migrateDb() ([]string, error) {
+	migrator := db.Migrator()
+	var created []string
+	for _, model := range dbModels {
+		if migrator.HasTable(model) {
+			continue
+		}
+		if err := migrator.CreateTable(model); err != nil {
+			return created, fmt.Errorf("创建数据库表失败: %v", err)
+		}
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err == nil {
+			created = append(created, stmt.Schema.Table)
+		}
+	}
+	if err := migrateColumns(migrator); err != nil {
+		return created, fmt.Errorf("补充数据库字段失败: %v", err)
+	}
+	if err := migrateIndexes(migrator); err != nil {
+		return created, fmt.Errorf("补充数据库索引失败: %v", err)
+	}
+	if _, err := runSchemaMigrations(); err != nil {
+		return created, err
+	}
+	return created, nil
+}
+
+// columnMigration 描述已有表上需要补充的字段
+type columnMigration struct {
+	model interface{}
+	field string
+}
+
+// columnMigrations 旧版本创建的表上需要补充的字段
+var columnMigrations = []columnMigration{
+	{&NetInterfaceCollectHour{}, "RecvBytes"},
+	{&NetInterfaceCollectHour{}, "SentBytes"},
+	{&JobRun{}, "Instance"},
+}
+
+// migrateColumns 为旧版本创建的表补充新增字段，已存在的字段不会重复添加
+func migrateColumns(migrator gorm.Migrator) error {
+	for _, col := range columnMigrations {
+		if migrator.HasColumn(col.model, col.field) {
+			continue
+		}
+		if err := migrator.AddColumn(col.model, col.field); err != nil {
+			return fmt.Errorf("添加字段 %s 失败: %v", col.field, err)
+		}
+	}
+	return nil
+}
+
+// indexMigration 描述已有表上需要补充的唯一索引，columns 为索引包含的字段，
+// keep 为删除重复数据时每组保留的一条：MIN(id) 保留最早写入的数据，MAX(id) 保留最近一次统计的结果
+type indexMigration struct {
+	model   interface{}
+	name    string
+	columns string
+	keep    string
+}
+
+// indexMigrations 旧版本创建的表上需要补充的唯一索引，用于忽略重复写入的指标和重复的统计结果
+var indexMigrations = []indexMigration{
+	{&CPUFieldsDb{}, "idx_cpu_sample", "host, cpu, timestamp", "MIN(id)"},
+	{&MemFieldsDb{}, "idx_mem_sample", "host, timestamp", "MIN(id)"},
+	{&DiskFieldsDb{}, "idx_disk_sample", "host, path, device, timestamp", "MIN(id)"},
+	{&NetInterfaceFieldsDb{}, "idx_net_sample", "host, interface, timestamp", "MIN(id)"},
+	// 旧版本每次重新统计最近 24 小时，同一小时后写入的结果包含的原始数据更完整
+	{&NetInterfaceCollectHour{}, "idx_net_hour", "host, interface, hour", "MAX(id)"},
+	{&NetInterfaceCollect5Min{}, "idx_net_5min", "host, interface, bucket", "MAX(id)"},
+}
+
+// migrateIndexes 为旧版本创建的表补充唯一索引，创建前删除已有的重复数据（每组按 keep 保留一条）
+func migrateIndexes(migrator gorm.Migrator) error {
+	for _, idx := range indexMigrations {
+		if migrator.HasIndex(idx.model, idx.name) {
+			continue
+		}
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(idx.model); err != nil {
+			return err
+		}
+		table := stmt.Schema.Table
+		// 子查询外再包一层派生表，MySQL 不允许在 DELETE 的子查询中直接引用被删除的表
+		res := db.Exec(fmt.Sprintf("DELETE FROM %s WHERE id NOT IN (SELECT id FROM (SELECT %s AS id FROM %s GROUP BY %s) AS keep_ids)",
+			table, idx.keep, table, idx.columns))
+		if res.Error != nil {
+			return fmt.Errorf("删除表 %s 的重复数据失败: %v", table, res.Error)
+		}
+		if res.RowsAffected > 0 {
+			storeLog.Info("已删除重复数据", "table", table, "rows", res.RowsAffected)
+		}
+		if err := migrator.CreateIndex(idx.model, idx.name); err != nil {
+			return fmt.Errorf("创建索引 %s 失败: %v", idx.name, err)
+		}
+	}
+	return nil
 }
 
 func parseLogLevel(level string) logger.LogLevel {
@@ -2,7 +2,6 @@ package main
 
 import (
 	"encoding/json"
-	"log"
 )
 
 // DiskFields 表示磁盘使用情况统计
@@ -22,13 +21,13 @@ type DiskFields struct {
 // DiskFieldsDb 用于数据库存储的 Disk 字段结构体
 // 使用 gorm 标签定义数据库字段映射
 type DiskFieldsDb struct {
-	ID        uint   `gorm:"primaryKey;autoIncrement" json:"id"`             // 主键ID，自增
-	Device    string `gorm:"type:varchar(100);not null;index" json:"device"` // 设备名称，如 "mmcblk0p2"
-	Fstype    string `gorm:"type:varchar(50);not null" json:"fstype"`        // 文件系统类型，如 "ext4"
-	Host      string `gorm:"type:varchar(100);not null;index" json:"host"`   // 主机名
-	Mode      string `gorm:"type:varchar(20);not null" json:"mode"`          // 挂载模式，如 "rw"
-	Path      string `gorm:"type:varchar(255);not null;index" json:"path"`   // 挂载路径，如 "/"
-	Timestamp int64  `gorm:"not null;index" json:"timestamp"`                // 时间戳（纳秒或毫秒）
+	ID        uint   `gorm:"primaryKey;autoIncrement" json:"id"`                                                    // 主键ID，自增
+	Device    string `gorm:"type:varchar(100);not null;index;uniqueIndex:idx_disk_sample,priority:3" json:"device"` // 设备名称，如 "mmcblk0p2"
+	Fstype    string `gorm:"type:varchar(50);not null" json:"fstype"`                                               // 文件系统类型，如 "ext4"
+	Host      string `gorm:"type:varchar(100);not null;index;uniqueIndex:idx_disk_sample,priority:1" json:"host"`   // 主机名
+	Mode      string `gorm:"type:varchar(20);not null" json:"mode"`                                                 // 挂载模式，如 "rw"
+	Path      string `gorm:"type:varchar(255);not null;index;uniqueIndex:idx_disk_sample,priority:2" json:"path"`   // 挂载路径，如 "/"
+	Timestamp int64  `gorm:"not null;index;uniqueIndex:idx_disk_sample,priority:4" json:"timestamp"`                // 时间戳（秒）
 
 	// 磁盘空间统计字段
 	Free        int64   `gorm:"not null" json:"free"`                            // 可用空间（字节）
@@ -78,13 +77,14 @@ func (d *DiskFields) FromFieldsMap(m map[string]interface{}) error {
 	return json.Unmarshal(b, d)
 }
 
-// SaveDiskInfo2DB 将解析后的 Disk 信息保存到数据库
-func SaveDiskInfo2DB(telegrafJson *TelegrafJson) {
+// SaveDiskInfo2DB 将解析后的 Disk 信息保存到数据库，返回是否为已保存过的重复数据
+func SaveDiskInfo2DB(telegrafJson *TelegrafJson) bool {
 	// 1. 解析 fields
 	var diskFields DiskFields
 	if err := diskFields.FromFieldsMap(telegrafJson.Fields); err != nil {
-		log.Printf("解析磁盘字段出错: %v", err)
-		return
+		fieldErrors.inc("disk")
+		storeLog.Warn("解析磁盘字段出错", "host", telegrafJson.Tags["host"], "err", err)
+		return false
 	}
 
 	// 2. 准备数据库模型
@@ -100,10 +100,13 @@ func SaveDiskInfo2DB(telegrafJson *TelegrafJson) {
 	)
 
 	// 3. 此处应调用 gorm.DB.Create(&diskDb) 来保存数据
-	log.Printf("准备保存磁盘数据: %+v", diskDb)
-	// 保存到数据库
-	if err := db.Create(&diskDb).Error; err != nil {
-		log.Printf("保存内存数据到数据库出错: %v", err)
-		return
+	if conf().Log.DebugPayloads {
+		storeLog.Debug("准备保存磁盘数据", "data", diskDb)
 	}
+	// 保存到数据库，重复的数据会被忽略
+	duplicate, err := insertSample("disk", &diskDb)
+	if err != nil {
+		storeLog.Error("保存磁盘数据到数据库出错", "err", err)
+	}
+	return duplicate
 }
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// 数据导出
+// export 子命令按时间段和主机导出指标表，CSV 使用数据库字段名作为表头，JSON 每行一条记录。
+
+// exportTable 可导出的表
+type exportTable struct {
+	name   string
+	model  interface{}
+	column string // 时间字段
+	unix   bool   // 时间字段为 Unix 时间戳（秒），否则为时间类型
+}
+
+var exportTables = []exportTable{
+	{"cpu", &CPUFieldsDb{}, "timestamp", true},
+	{"mem", &MemFieldsDb{}, "timestamp", true},
+	{"disk", &DiskFieldsDb{}, "timestamp", true},
+	{"net", &NetInterfaceFieldsDb{}, "timestamp", true},
+	{"net_hour", &NetInterfaceCollectHour{}, "hour", false},
+	{"net_5min", &NetInterfaceCollect5Min{}, "bucket", false},
+}
+
+// runExport 导出指标数据
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	table := fs.String("table", "", "要导出的数据: cpu、mem、disk、net、net_hour、net_5min")
+	fromStr := fs.String("from", "", "开始时间（包含），默认不限")
+	toStr := fs.String("to", "", "结束时间（不包含），默认不限")
+	host := fs.String("host", "", "只导出指定主机")
+	format := fs.String("format", "csv", "输出格式: csv 或 json（每行一条记录）")
+	output := fs.String("o", "-", "输出文件，- 表示标准输出")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var t exportTable
+	for _, et := range exportTables {
+		if et.name == *table {
+			t = et
+		}
+	}
+	if t.model == nil {
+		return fmt.Errorf("未知的数据: %q，可选 cpu、mem、disk、net、net_hour、net_5min", *table)
+	}
+	if *format != "csv" && *format != "json" {
+		return fmt.Errorf("不支持的输出格式: %q", *format)
+	}
+
+	q := db.Model(t.model)
+	if *fromStr != "" {
+		from, err := parseCLITime(*fromStr)
+		if err != nil {
+			return err
+		}
+		q = q.Where(t.column+" >= ?", t.timeValue(from))
+	}
+	if *toStr != "" {
+		to, err := parseCLITime(*toStr)
+		if err != nil {
+			return err
+		}
+		q = q.Where(t.column+" < ?", t.timeValue(to))
+	}
+	if *host != "" {
+		q = q.Where("host = ?", *host)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	n, err := exportRows(q.Order(t.column), t.model, w, *format)
+	if err != nil {
+		return err
+	}
+	if *output != "-" {
+		fmt.Printf("已导出 %d 条记录到 %s\n", n, *output)
+	}
+	return nil
+}
+
+// timeValue 将时间转换为时间字段的类型
+func (t exportTable) timeValue(ts time.Time) interface{} {
+	if t.unix {
+		return ts.Unix()
+	}
+	return ts
+}
+
+// exportRows 逐行读取查询结果并写出，返回导出的条数
+func exportRows(q *gorm.DB, model interface{}, w io.Writer, format string) (int, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return 0, err
+	}
+	fields := stmt.Schema.Fields
+
+	var (
+		write func(row reflect.Value) error
+		flush func() error
+	)
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		write = func(row reflect.Value) error {
+			record := make(map[string]interface{}, len(fields))
+			for _, f := range fields {
+				record[f.DBName], _ = f.ValueOf(context.Background(), row)
+			}
+			return enc.Encode(record)
+		}
+		flush = func() error { return nil }
+	default:
+		cw := csv.NewWriter(w)
+		header := make([]string, len(fields))
+		for i, f := range fields {
+			header[i] = f.DBName
+		}
+		if err := cw.Write(header); err != nil {
+			return 0, err
+		}
+		write = func(row reflect.Value) error {
+			return cw.Write(csvRecord(fields, row))
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	}
+
+	rows, err := q.Rows()
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	typ := reflect.TypeOf(model).Elem()
+	for rows.Next() {
+		row := reflect.New(typ)
+		if err := db.ScanRows(rows, row.Interface()); err != nil {
+			return n, err
+		}
+		if err := write(row.Elem()); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	return n, flush()
+}
+
+// csvRecord 将一行数据转换为 CSV 记录，时间使用 RFC3339 格式
+func csvRecord(fields []*schema.Field, row reflect.Value) []string {
+	record := make([]string, len(fields))
+	for i, f := range fields {
+		v, _ := f.ValueOf(context.Background(), row)
+		switch val := v.(type) {
+		case time.Time:
+			record[i] = val.Format(time.RFC3339)
+		case float64:
+			record[i] = strconv.FormatFloat(val, 'f', -1, 64)
+		default:
+			record[i] = fmt.Sprint(val)
+		}
+	}
+	return record
+}
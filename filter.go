@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// 写入过滤和标签改写
+// ingest.rules 中的规则在解析之后、保存之前按顺序作用于每条数据，条件包括测量名称（glob）、
+// 标签匹配（格式同告警规则的 on 部分，= 的值可以使用 * 和 ? 通配）和字段条件（如 bytes_recv == 0），
+// 多个条件需同时满足，未填写的条件视为满足。丢弃的数据计入 moc_ingest_dropped_metrics_total。
+//
+//	drop           丢弃满足条件的数据
+//	keep           测量名称匹配但不满足其余条件的数据被丢弃，其他测量不受影响
+//	drop_zero      丢弃满足条件且 fields 中的字段（默认全部数值字段）都为 0 的数据，如未使用的 dummy0、sit0 网卡；
+//	               逐条判断、不按序列记录状态，同一序列只有部分数据全为 0 时只丢弃这部分
+//	set_tag        设置标签 tag 为 value
+//	remove_tag     删除标签 tag
+//	rename_tag     将标签 tag 改名为 to
+//	map_tag        按 map 替换标签 tag 的值，如 end0 → eth0
+//	lowercase_tag  将标签 tag 的值转换为小写
+
+// ingestRuleConfig 配置文件中的单条写入规则
+type ingestRuleConfig struct {
+	Action      string            `json:"action"`      // 动作，见上方说明
+	Measurement string            `json:"measurement"` // 测量名称，支持 glob，为空匹配全部
+	Match       string            `json:"match"`       // 标签匹配条件，如 interface=~dummy.*|sit.*,host=pi*
+	Field       string            `json:"field"`       // 字段条件，如 bytes_recv == 0
+	Tag         string            `json:"tag"`         // 改写的标签名
+	Value       string            `json:"value"`       // set_tag 设置的值
+	To          string            `json:"to"`          // rename_tag 的新标签名
+	Map         map[string]string `json:"map"`         // map_tag 的值映射
+	Fields      []string          `json:"fields"`      // drop_zero 检查的字段，为空时检查全部数值字段
+}
+
+// ingestRule 解析后的写入规则
+type ingestRule struct {
+	cfg         ingestRuleConfig
+	measurement *regexp.Regexp
+	matchers    []labelMatcher
+	field       *alertRule // 只使用 Field、Op、Threshold
+}
+
+// globRegexp 将 glob（* 匹配任意字符，包括 /；? 匹配单个字符）转换为完整匹配的正则
+func globRegexp(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// parseFieldCondition 解析字段条件: <字段> <运算符> <数值>
+func parseFieldCondition(s string) (*alertRule, error) {
+	tokens := strings.Fields(s)
+	if len(tokens) != 3 {
+		return nil, fmt.Errorf("字段条件应为 <字段> <运算符> <数值>: %q", s)
+	}
+	// 借用告警规则的解析，measurement 部分只用于满足格式
+	return parseAlertRule(alertRuleConfig{Expr: "_." + s})
+}
+
+// parseIngestRule 解析单条写入规则
+func parseIngestRule(cfg ingestRuleConfig) (*ingestRule, error) {
+	r := &ingestRule{cfg: cfg}
+	switch cfg.Action {
+	case "drop", "keep", "drop_zero":
+	case "set_tag", "remove_tag", "lowercase_tag":
+		if cfg.Tag == "" {
+			return nil, fmt.Errorf("%s 需要指定 tag", cfg.Action)
+		}
+	case "rename_tag":
+		if cfg.Tag == "" || cfg.To == "" {
+			return nil, fmt.Errorf("rename_tag 需要指定 tag 和 to")
+		}
+	case "map_tag":
+		if cfg.Tag == "" || len(cfg.Map) == 0 {
+			return nil, fmt.Errorf("map_tag 需要指定 tag 和 map")
+		}
+	default:
+		return nil, fmt.Errorf("不支持的动作: %q", cfg.Action)
+	}
+
+	if cfg.Measurement != "" {
+		r.measurement = globRegexp(cfg.Measurement)
+	}
+	matchers, err := parseLabelMatchers(strings.ReplaceAll(cfg.Match, " ", ""))
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range matchers {
+		// = 的值包含通配符时按 glob 匹配
+		if m.Op == "=" && strings.ContainsAny(m.Value, "*?") {
+			matchers[i].Op = "=~"
+			matchers[i].re = globRegexp(m.Value)
+		}
+	}
+	r.matchers = matchers
+	if cfg.Field != "" {
+		if r.field, err = parseFieldCondition(cfg.Field); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// parseIngestRules 解析全部写入规则
+func parseIngestRules(cfgs []ingestRuleConfig) ([]*ingestRule, error) {
+	rules := make([]*ingestRule, 0, len(cfgs))
+	for i, cfg := range cfgs {
+		r, err := parseIngestRule(cfg)
+		if err != nil {
+			return nil, &fieldError{Field: fmt.Sprintf("ingest.rules[%d]", i), Err: err}
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// matchesMeasurement 判断测量名称是否满足规则
+func (r *ingestRule) matchesMeasurement(m *TelegrafJson) bool {
+	return r.measurement == nil || r.measurement.MatchString(m.Name)
+}
+
+// matchesRest 判断标签和字段条件是否满足规则
+func (r *ingestRule) matchesRest(m *TelegrafJson) bool {
+	if !matchLabels(r.matchers, m.Tags) {
+		return false
+	}
+	if r.field != nil {
+		v, ok := fieldValue(m.Fields, r.field.Field)
+		if !ok || !r.field.compare(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// allZero 判断数据的指定字段是否都为 0，names 为空时检查全部数值字段；没有可检查的字段时返回 false
+func allZero(m *TelegrafJson, names []string) bool {
+	if len(names) == 0 {
+		for name := range m.Fields {
+			names = append(names, name)
+		}
+	}
+	numeric := false
+	for _, name := range names {
+		v, ok := fieldValue(m.Fields, name)
+		if !ok {
+			continue
+		}
+		if v != 0 {
+			return false
+		}
+		numeric = true
+	}
+	return numeric
+}
+
+// apply 对单条数据执行规则，返回丢弃原因，保留时返回空字符串
+func (r *ingestRule) apply(m *TelegrafJson) string {
+	if !r.matchesMeasurement(m) {
+		return ""
+	}
+	matched := r.matchesRest(m)
+	if r.cfg.Action == "keep" {
+		if !matched {
+			return "filter"
+		}
+		return ""
+	}
+	if !matched {
+		return ""
+	}
+
+	switch r.cfg.Action {
+	case "drop":
+		return "filter"
+	case "drop_zero":
+		if allZero(m, r.cfg.Fields) {
+			return "zero"
+		}
+	case "set_tag":
+		if m.Tags == nil {
+			m.Tags = make(map[string]string)
+		}
+		m.Tags[r.cfg.Tag] = r.cfg.Value
+	case "remove_tag":
+		delete(m.Tags, r.cfg.Tag)
+	case "rename_tag":
+		if v, ok := m.Tags[r.cfg.Tag]; ok {
+			delete(m.Tags, r.cfg.Tag)
+			m.Tags[r.cfg.To] = v
+		}
+	case "map_tag":
+		if v, ok := r.cfg.Map[m.Tags[r.cfg.Tag]]; ok {
+			if m.Tags == nil {
+				m.Tags = make(map[string]string)
+			}
+			m.Tags[r.cfg.Tag] = v
+		}
+	case "lowercase_tag":
+		if v, ok := m.Tags[r.cfg.Tag]; ok {
+			m.Tags[r.cfg.Tag] = strings.ToLower(v)
+		}
+	}
+	return ""
+}
+
+// compiledIngestRules 按配置缓存的写入规则，配置重新加载后重新解析
+type compiledIngestRules struct {
+	cfg   *AppConfig
+	rules []*ingestRule
+}
+
+var ingestRules atomic.Pointer[compiledIngestRules]
+
+// currentIngestRules 返回当前配置的写入规则，配置已通过 validateConfig 校验
+func currentIngestRules() []*ingestRule {
+	c := conf()
+	if cached := ingestRules.Load(); cached != nil && cached.cfg == c {
+		return cached.rules
+	}
+	rules, err := parseIngestRules(c.Ingest.Rules)
+	if err != nil {
+		ingestLog.Error("解析写入规则失败，不过滤数据", "err", err)
+		rules = nil
+	}
+	ingestRules.Store(&compiledIngestRules{cfg: c, rules: rules})
+	return rules
+}
+
+// filterMetrics 按 ingest.rules 过滤和改写数据，返回保留的数据
+func filterMetrics(endpoint string, metrics []TelegrafJson) []TelegrafJson {
+	rules := currentIngestRules()
+	if len(rules) == 0 {
+		return metrics
+	}
+	kept := metrics[:0]
+	for i := range metrics {
+		m := metrics[i]
+		reason := ""
+		for _, r := range rules {
+			if reason = r.apply(&m); reason != "" {
+				break
+			}
+		}
+		if reason != "" {
+			droppedMetrics.inc(endpoint, reason)
+			continue
+		}
+		kept = append(kept, m)
+	}
+	return kept
+}
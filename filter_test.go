@@ -0,0 +1,145 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFilterMetrics(t *testing.T) {
+	netMetric := func(iface string, recv float64) TelegrafJson {
+		return TelegrafJson{
+			Name:   "net",
+			Tags:   map[string]string{"host": "pi-1", "interface": iface},
+			Fields: map[string]interface{}{"bytes_recv": recv, "bytes_sent": 0.0},
+		}
+	}
+	tests := []struct {
+		name  string
+		rules []ingestRuleConfig
+		in    TelegrafJson
+		want  *TelegrafJson // nil 表示丢弃
+	}{
+		{
+			name:  "drop 按标签通配符丢弃",
+			rules: []ingestRuleConfig{{Action: "drop", Measurement: "net", Match: "interface=dummy*"}},
+			in:    netMetric("dummy0", 1),
+		},
+		{
+			name:  "drop 不匹配时保留",
+			rules: []ingestRuleConfig{{Action: "drop", Measurement: "net", Match: "interface=dummy*"}},
+			in:    netMetric("eth0", 1),
+			want:  ptr(netMetric("eth0", 1)),
+		},
+		{
+			name:  "drop 按字段条件",
+			rules: []ingestRuleConfig{{Action: "drop", Measurement: "net", Field: "bytes_recv == 0"}},
+			in:    netMetric("eth0", 0),
+		},
+		{
+			name:  "keep 丢弃同一测量中不满足条件的数据",
+			rules: []ingestRuleConfig{{Action: "keep", Measurement: "net", Match: "interface=eth*"}},
+			in:    netMetric("wlan0", 1),
+		},
+		{
+			name:  "keep 不影响其他测量",
+			rules: []ingestRuleConfig{{Action: "keep", Measurement: "net", Match: "interface=eth*"}},
+			in:    TelegrafJson{Name: "cpu", Tags: map[string]string{"cpu": "cpu-total"}},
+			want:  &TelegrafJson{Name: "cpu", Tags: map[string]string{"cpu": "cpu-total"}},
+		},
+		{
+			name:  "drop_zero 全部数值字段为 0",
+			rules: []ingestRuleConfig{{Action: "drop_zero", Measurement: "net"}},
+			in:    netMetric("sit0", 0),
+		},
+		{
+			name:  "drop_zero 有非 0 字段时保留",
+			rules: []ingestRuleConfig{{Action: "drop_zero", Measurement: "net"}},
+			in:    netMetric("eth0", 5),
+			want:  ptr(netMetric("eth0", 5)),
+		},
+		{
+			name:  "drop_zero 只检查指定字段",
+			rules: []ingestRuleConfig{{Action: "drop_zero", Measurement: "net", Fields: []string{"bytes_sent"}}},
+			in:    netMetric("eth0", 5),
+		},
+		{
+			name:  "drop_zero 没有数值字段时保留",
+			rules: []ingestRuleConfig{{Action: "drop_zero"}},
+			in:    TelegrafJson{Name: "net", Fields: map[string]interface{}{"state": "up"}},
+			want:  &TelegrafJson{Name: "net", Fields: map[string]interface{}{"state": "up"}},
+		},
+		{
+			name:  "map_tag 替换标签值",
+			rules: []ingestRuleConfig{{Action: "map_tag", Tag: "interface", Map: map[string]string{"end0": "eth0"}}},
+			in:    netMetric("end0", 1),
+			want:  ptr(netMetric("eth0", 1)),
+		},
+		{
+			name:  "map_tag 不在映射中的值不变",
+			rules: []ingestRuleConfig{{Action: "map_tag", Tag: "interface", Map: map[string]string{"end0": "eth0"}}},
+			in:    netMetric("wlan0", 1),
+			want:  ptr(netMetric("wlan0", 1)),
+		},
+		{
+			name:  "map_tag 没有标签",
+			rules: []ingestRuleConfig{{Action: "map_tag", Tag: "interface", Map: map[string]string{"": "unknown"}}},
+			in:    TelegrafJson{Name: "net"},
+			want:  &TelegrafJson{Name: "net", Tags: map[string]string{"interface": "unknown"}},
+		},
+		{
+			name:  "set_tag 没有标签",
+			rules: []ingestRuleConfig{{Action: "set_tag", Tag: "env", Value: "prod"}},
+			in:    TelegrafJson{Name: "mem"},
+			want:  &TelegrafJson{Name: "mem", Tags: map[string]string{"env": "prod"}},
+		},
+		{
+			name:  "remove_tag 和 rename_tag 没有标签",
+			rules: []ingestRuleConfig{{Action: "remove_tag", Tag: "env"}, {Action: "rename_tag", Tag: "a", To: "b"}, {Action: "lowercase_tag", Tag: "host"}},
+			in:    TelegrafJson{Name: "mem"},
+			want:  &TelegrafJson{Name: "mem"},
+		},
+		{
+			name: "规则按顺序执行",
+			rules: []ingestRuleConfig{
+				{Action: "map_tag", Tag: "interface", Map: map[string]string{"end0": "dummy0"}},
+				{Action: "drop", Match: "interface=dummy*"},
+			},
+			in: netMetric("end0", 1),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(c *AppConfig) { c.Ingest.Rules = tt.rules })
+			got := filterMetrics("test", []TelegrafJson{tt.in})
+			switch {
+			case tt.want == nil && len(got) != 0:
+				t.Errorf("期望丢弃，实际保留 %+v", got[0])
+			case tt.want != nil && len(got) != 1:
+				t.Errorf("期望保留，实际被丢弃")
+			case tt.want != nil && !reflect.DeepEqual(got[0], *tt.want):
+				t.Errorf("结果 %+v，期望 %+v", got[0], *tt.want)
+			}
+		})
+	}
+}
+
+func TestParseIngestRuleErrors(t *testing.T) {
+	tests := []ingestRuleConfig{
+		{Action: "unknown"},
+		{Action: "set_tag"},
+		{Action: "rename_tag", Tag: "a"},
+		{Action: "map_tag", Tag: "a"},
+		{Action: "drop", Field: "bytes_recv =="},
+		{Action: "drop", Match: "interface=~("},
+	}
+	for _, cfg := range tests {
+		if _, err := parseIngestRule(cfg); err == nil {
+			t.Errorf("parseIngestRule(%+v) 期望出错", cfg)
+		}
+	}
+}
+
+// ptr 返回数据的副本指针
+func ptr(m TelegrafJson) *TelegrafJson {
+	return &m
+}
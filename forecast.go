@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// 磁盘写满预测
+// 对 disk_metrics 中最近一段时间的已用空间做线性回归，估算每个主机/挂载路径的增长速度和写满时间，
+// 结果保存到 disk_forecasts 表，预计写满时间小于 alert_within 时触发 disk_fill_forecast 告警。
+
+// forecastConfig 磁盘写满预测配置
+type forecastConfig struct {
+	Enable      bool   `json:"enable"`
+	Window      string `json:"window"`       // 拟合使用的历史数据时长，默认 168h
+	AlertWithin string `json:"alert_within"` // 预计在此时间内写满时告警，默认 72h
+	MinSamples  int    `json:"min_samples"`  // 最少样本数（按 10 分钟聚合后），默认 12
+}
+
+// DiskForecast 磁盘写满预测结果，每次计算追加一批记录
+type DiskForecast struct {
+	ID              uint       `gorm:"primaryKey;autoIncrement" json:"id"`                               // 数据库主键
+	Host            string     `gorm:"type:varchar(100);not null;index:idx_forecast_series" json:"host"` // 主机名
+	Path            string     `gorm:"type:varchar(255);not null;index:idx_forecast_series" json:"path"` // 挂载路径
+	Samples         int        `gorm:"not null" json:"samples"`                                          // 参与拟合的样本数
+	Used            int64      `gorm:"not null" json:"used"`                                             // 最新已用空间（字节）
+	Free            int64      `gorm:"not null" json:"free"`                                             // 最新可用空间（字节）
+	Total           int64      `gorm:"not null" json:"total"`                                            // 总空间（字节）
+	GrowthPerHour   float64    `gorm:"type:decimal(20,2);not null" json:"growth_per_hour"`               // 已用空间增长速度（字节/小时）
+	R2              float64    `gorm:"type:decimal(10,6);not null" json:"r2"`                            // 拟合优度
+	HoursUntilFull  *float64   `gorm:"type:decimal(20,2)" json:"hours_until_full"`                       // 预计写满的剩余小时数，不增长时为空
+	PredictedFullAt *time.Time `json:"predicted_full_at"`                                                // 预计写满时间
+	ComputedAt      time.Time  `gorm:"not null;index:idx_forecast_series;index" json:"computed_at"`      // 计算时间
+}
+
+// TableName 指定 DiskForecast 的表名
+func (DiskForecast) TableName() string {
+	return "disk_forecasts"
+}
+
+// diskSample 按 10 分钟聚合后的磁盘样本
+type diskSample struct {
+	Host  string
+	Path  string
+	Ts    int64
+	Used  float64
+	Free  int64
+	Total int64
+}
+
+// linearRegression 最小二乘拟合 y = a + b*x，返回斜率 b 和拟合优度 r²
+func linearRegression(xs, ys []float64) (float64, float64) {
+	n := float64(len(xs))
+	if n < 2 {
+		return 0, 0
+	}
+	var sumX, sumY float64
+	for i := range xs {
+		sumX += xs[i]
+		sumY += ys[i]
+	}
+	meanX, meanY := sumX/n, sumY/n
+
+	var sxx, sxy, syy float64
+	for i := range xs {
+		dx, dy := xs[i]-meanX, ys[i]-meanY
+		sxx += dx * dx
+		sxy += dx * dy
+		syy += dy * dy
+	}
+	if sxx == 0 {
+		return 0, 0
+	}
+	slope := sxy / sxx
+	r2 := 1.0
+	if syy > 0 {
+		r2 = sxy * sxy / (sxx * syy)
+	}
+	return slope, r2
+}
+
+// forecastDuration 读取配置中的时长，未配置或无效时使用默认值
+func forecastDuration(value string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return d
+	}
+	return def
+}
+
+// forecastDiskFull 定时任务，计算所有主机/挂载路径的写满预测，ctx 取消后不再写入
+func forecastDiskFull(ctx context.Context) error {
+	cronLog.Info("forecastDiskFull 执行中")
+	now := time.Now()
+	window := forecastDuration(conf().Forecast.Window, 7*24*time.Hour)
+	alertWithin := forecastDuration(conf().Forecast.AlertWithin, 72*time.Hour)
+	minSamples := conf().Forecast.MinSamples
+	if minSamples <= 0 {
+		minSamples = 12
+	}
+
+	// 按 10 分钟聚合，减少参与拟合的数据量
+	var samples []diskSample
+	if err := db.WithContext(ctx).Model(&DiskFieldsDb{}).
+		Select("host, path, FLOOR(timestamp / 600) * 600 AS ts, AVG(used) AS used, MIN(free) AS free, MAX(total) AS total").
+		Where("timestamp >= ?", now.Add(-window).Unix()).
+		Group("host, path, FLOOR(timestamp / 600)").
+		Order("host, path, ts").
+		Scan(&samples).Error; err != nil {
+		return fmt.Errorf("failed to fetch disk samples: %v", err)
+	}
+
+	type seriesKey struct {
+		Host string
+		Path string
+	}
+	var keys []seriesKey
+	series := make(map[seriesKey][]diskSample)
+	for _, s := range samples {
+		k := seriesKey{Host: s.Host, Path: s.Path}
+		if _, ok := series[k]; !ok {
+			keys = append(keys, k)
+		}
+		series[k] = append(series[k], s)
+	}
+
+	var forecasts []DiskForecast
+	for _, k := range keys {
+		points := series[k]
+		if len(points) < minSamples {
+			continue
+		}
+		xs := make([]float64, len(points))
+		ys := make([]float64, len(points))
+		for i, p := range points {
+			xs[i] = float64(p.Ts - points[0].Ts)
+			ys[i] = p.Used
+		}
+		slope, r2 := linearRegression(xs, ys)
+		latest := points[len(points)-1]
+
+		f := DiskForecast{
+			Host:          k.Host,
+			Path:          k.Path,
+			Samples:       len(points),
+			Used:          int64(latest.Used),
+			Free:          latest.Free,
+			Total:         latest.Total,
+			GrowthPerHour: slope * 3600,
+			R2:            r2,
+			ComputedAt:    now,
+		}
+		if slope > 0 {
+			hours := float64(latest.Free) / slope / 3600
+			fullAt := time.Unix(latest.Ts, 0).Add(time.Duration(hours * float64(time.Hour)))
+			f.HoursUntilFull = &hours
+			f.PredictedFullAt = &fullAt
+		}
+		forecasts = append(forecasts, f)
+
+		fillSoon := f.HoursUntilFull != nil && *f.HoursUntilFull <= alertWithin.Hours()
+		var hours float64
+		if f.HoursUntilFull != nil {
+			hours = *f.HoursUntilFull
+		}
+		alerts.update(alertSignal{
+			Rule:     "disk_fill_forecast",
+			Severity: "warning",
+			Summary:  fmt.Sprintf("%s 预计 %.1f 小时内写满 (增长 %.2f MB/h)", k.Path, hours, f.GrowthPerHour/1024/1024),
+			Labels:   map[string]string{"host": k.Host, "path": k.Path},
+			Value:    hours,
+		}, fillSoon, now)
+	}
+
+	if len(forecasts) > 0 {
+		if err := db.WithContext(ctx).CreateInBatches(forecasts, 100).Error; err != nil {
+			return fmt.Errorf("failed to insert disk forecasts: %v", err)
+		}
+	}
+
+	// 预测结果保留 30 天
+	if err := db.WithContext(ctx).Where("computed_at < ?", now.Add(-30*24*time.Hour)).Delete(&DiskForecast{}).Error; err != nil {
+		cronLog.Error("Failed to clear old disk forecasts", "err", err)
+	}
+	cronLog.Info("forecastDiskFull 成功", "forecasts", len(forecasts))
+	return nil
+}
+
+// handleDiskForecast 返回最近一次计算的磁盘写满预测，参数 host、path 用于过滤
+func handleDiskForecast(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "只接受 GET 请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var latest DiskForecast
+	if err := db.Order("computed_at DESC").Limit(1).Find(&latest).Error; err != nil {
+		http.Error(w, "查询磁盘预测失败", http.StatusInternalServerError)
+		apiLog.Error("查询磁盘预测失败", "err", err)
+		return
+	}
+	result := []DiskForecast{}
+	if latest.ID == 0 {
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+
+	query := r.URL.Query()
+	tx := db.Where("computed_at = ?", latest.ComputedAt).Order("host, path")
+	if host := query.Get("host"); host != "" {
+		tx = tx.Where("host = ?", host)
+	}
+	if path := query.Get("path"); path != "" {
+		tx = tx.Where("path = ?", path)
+	}
+	if err := tx.Find(&result).Error; err != nil {
+		http.Error(w, "查询磁盘预测失败", http.StatusInternalServerError)
+		apiLog.Error("查询磁盘预测失败", "err", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
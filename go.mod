@@ -3,8 +3,11 @@ module MonitorCollect
 go 1.25
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/influxdata/line-protocol/v2 v2.2.1
 	github.com/robfig/cron/v3 v3.0.1
+	golang.org/x/crypto v0.45.0
+	gopkg.in/yaml.v3 v3.0.1
 	gorm.io/driver/mysql v1.6.0
 	gorm.io/gorm v1.31.1
 )
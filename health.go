@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// 健康检查、就绪检查和运行状态
+// /healthz 只表示进程存活；/readyz 检查数据库连接、表结构是否完整、通知队列是否积压，供负载均衡使用；
+// /status 输出版本、运行时间、配置摘要、定时任务下次执行时间和各主机最近上报时间。
+// 由 systemd 以 Type=notify 启动时，服务就绪后发送 READY=1，并按 WatchdogSec 定时发送 WATCHDOG=1，
+// 调度器长时间没有触发任务（进程卡住）时不发送，由 systemd 重启服务；数据库无法连接不影响 watchdog，
+// 只通过 /readyz 和 systemctl status 中的 STATUS 报告，重启无助于恢复数据库，反而丢失内存中的告警和心跳状态。
+
+// version 构建版本，通过 -ldflags "-X main.version=v1.2.3" 设置
+var version = "dev"
+
+// notifyQueueHighWater 通知队列超过该比例时视为未就绪
+const notifyQueueHighWater = 0.9
+
+// schedulerStaleAfter 调度器超过该时间没有触发任何任务时视为卡住，flushHeartbeats 等任务每分钟触发一次
+const schedulerStaleAfter = 3 * time.Minute
+
+// lastSchedulerTick 调度器最近一次触发任务的时间（UnixNano）
+var lastSchedulerTick atomic.Int64
+
+// readinessCheck 单项就绪检查结果
+type readinessCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// checkReadiness 依次执行各项就绪检查
+func checkReadiness(ctx context.Context) []readinessCheck {
+	checks := []struct {
+		name string
+		fn   func(context.Context) error
+	}{
+		{"database", checkDatabase},
+		{"migrations", checkMigrations},
+		{"notify_queue", checkNotifyQueue},
+	}
+	result := make([]readinessCheck, 0, len(checks))
+	for _, c := range checks {
+		check := readinessCheck{Name: c.name, OK: true}
+		if err := c.fn(ctx); err != nil {
+			check.OK = false
+			check.Error = err.Error()
+		}
+		result = append(result, check)
+	}
+	return result
+}
+
+// checkDatabase 检查数据库是否可以连接
+func checkDatabase(ctx context.Context) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// checkMigrations 检查所有表和补充字段是否已创建
+func checkMigrations(ctx context.Context) error {
+	migrator := db.WithContext(ctx).Migrator()
+	for _, model := range dbModels {
+		if !migrator.HasTable(model) {
+			return fmt.Errorf("缺少数据库表: %T", model)
+		}
+	}
+	for _, col := range columnMigrations {
+		if !migrator.HasColumn(col.model, col.field) {
+			return fmt.Errorf("缺少数据库字段: %T.%s", col.model, col.field)
+		}
+	}
+	return nil
+}
+
+// checkNotifyQueue 检查通知队列是否积压
+func checkNotifyQueue(context.Context) error {
+	d := dispatcher.Load()
+	if d == nil {
+		return nil
+	}
+	depth, capacity := len(d.queue), cap(d.queue)
+	if float64(depth) >= float64(capacity)*notifyQueueHighWater {
+		return fmt.Errorf("通知队列积压: %d/%d", depth, capacity)
+	}
+	return nil
+}
+
+// markSchedulerTick 记录调度器触发了一次任务
+func markSchedulerTick() {
+	lastSchedulerTick.Store(time.Now().UnixNano())
+}
+
+// checkScheduler 检查调度器最近是否触发过任务，启动后尚未触发时从启动时间算起
+func checkScheduler(context.Context) error {
+	last := startTime
+	if n := lastSchedulerTick.Load(); n != 0 {
+		last = time.Unix(0, n)
+	}
+	if since := time.Since(last); since > schedulerStaleAfter {
+		return fmt.Errorf("调度器已 %s 没有触发任务", since.Round(time.Second))
+	}
+	return nil
+}
+
+// handleHealthz 进程存活检查
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz 就绪检查，任一检查失败时返回 503
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	checks := checkReadiness(ctx)
+	status := http.StatusOK
+	for _, c := range checks {
+		if !c.OK {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"ready":  status == http.StatusOK,
+		"checks": checks,
+	})
+}
+
+// jobStatus 定时任务的调度信息
+type jobStatus struct {
+	Name string    `json:"name"`
+	Prev time.Time `json:"prev"`
+	Next time.Time `json:"next"`
+}
+
+// hostLastIngest 主机最近一次上报时间
+type hostLastIngest struct {
+	Host     string    `json:"host"`
+	LastSeen time.Time `json:"last_seen"`
+	Down     bool      `json:"down"`
+}
+
+// serviceStatus /status 的响应
+type serviceStatus struct {
+	Version       string                 `json:"version"`
+	Instance      string                 `json:"instance"`
+	Leader        bool                   `json:"leader"` // 是否执行全局任务，未启用 cluster 时始终为 true
+	GoVersion     string                 `json:"go_version"`
+	StartedAt     time.Time              `json:"started_at"`
+	UptimeSeconds int64                  `json:"uptime_seconds"`
+	Config        map[string]interface{} `json:"config"`
+	Jobs          []jobStatus            `json:"jobs"`
+	Hosts         []hostLastIngest       `json:"hosts"`
+}
+
+// configSummary 配置摘要，不包含密码、Token 等敏感信息
+func configSummary() map[string]interface{} {
+	return map[string]interface{}{
+		"server_port":      conf().ServerPort,
+		"database":         fmt.Sprintf("%s@%s:%s/%s", conf().Database.User, conf().Database.Host, conf().Database.Port, conf().Database.DBName),
+		"log_level":        conf().LogLevel,
+		"cron":             conf().Cron.Enable,
+		"quotas":           len(conf().Quotas),
+		"alert_rules":      len(conf().Alert.Rules),
+		"alert":            conf().Alert.Enable,
+		"heartbeat":        conf().Heartbeat.Enable,
+		"notify_channels":  len(conf().Notify.Channels),
+		"forecast":         conf().Forecast.Enable,
+		"anomaly":          conf().Anomaly.Enable,
+		"maintenance":      len(conf().Maintenance),
+		"auth":             conf().Auth.Enable,
+		"tls":              conf().TLS.Enable,
+		"self_metrics":     conf().SelfMetrics.Store,
+		"shutdown_timeout": shutdownTimeout().String(),
+		"cluster":          conf().Cluster.Enable,
+		"json_precision":   conf().Ingest.JSONPrecision,
+		"cpu_store":        cpuStorePolicy(),
+		"ingest_rules":     len(conf().Ingest.Rules),
+	}
+}
+
+// scheduledJobs 返回按下次执行时间排序的定时任务
+func scheduledJobs() []jobStatus {
+	c := scheduler.Load()
+	if c == nil {
+		return nil
+	}
+	var jobs []jobStatus
+	for _, e := range c.Entries() {
+		job := jobStatus{Prev: e.Prev, Next: e.Next}
+		if j, ok := e.Job.(*cronJob); ok {
+			job.Name = j.name
+		}
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Next.Before(jobs[j].Next) })
+	return jobs
+}
+
+// handleStatus 输出服务运行状态
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "只接受 GET 请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var hosts []hostLastIngest
+	for _, inv := range heartbeats.inventory() {
+		hosts = append(hosts, hostLastIngest{Host: inv.Host, LastSeen: inv.LastSeen, Down: inv.Down})
+	}
+	writeJSON(w, http.StatusOK, serviceStatus{
+		Version:       version,
+		Instance:      instanceID(),
+		Leader:        isLeader(),
+		GoVersion:     runtime.Version(),
+		StartedAt:     startTime,
+		UptimeSeconds: int64(time.Since(startTime).Seconds()),
+		Config:        configSummary(),
+		Jobs:          scheduledJobs(),
+		Hosts:         hosts,
+	})
+}
+
+// sdNotify 向 systemd 发送状态通知，未由 systemd 以 Type=notify 启动时不做任何事
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// 以 @ 开头的是抽象命名空间套接字
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval 读取 systemd 的 WatchdogSec 设置，未启用时返回 0
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// watchdogStatus 返回 systemd 的 STATUS 文本，数据库检查同 /readyz 的 database 检查
+func watchdogStatus(ctx context.Context, timeout time.Duration) string {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := checkDatabase(ctx); err != nil {
+		return "数据库无法连接: " + err.Error()
+	}
+	return "运行中"
+}
+
+// startWatchdog 按 WatchdogSec 的一半间隔在调度器正常时发送 WATCHDOG=1 并更新 STATUS，ctx 结束时停止
+func startWatchdog(ctx context.Context) {
+	interval := watchdogInterval()
+	if interval == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := checkScheduler(ctx); err != nil {
+					serverLog.Warn("调度器没有响应，不发送 systemd watchdog 通知", "err", err)
+					continue
+				}
+				if err := sdNotify("WATCHDOG=1\nSTATUS=" + watchdogStatus(ctx, interval/4)); err != nil {
+					serverLog.Warn("发送 systemd watchdog 通知失败", "err", err)
+				}
+			}
+		}
+	}()
+	serverLog.Info("systemd watchdog 已启用", "interval", interval/2)
+}
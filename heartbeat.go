@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 主机心跳
+// 数据到达时记录每个主机（及每个 measurement）的最后上报时间，并估算通常的上报间隔，每分钟写入 host_heartbeats 表。
+// 主机超过 multiple 倍上报间隔没有数据时触发 host_down 告警，数据恢复后自动恢复告警。
+// 多实例部署时主机可能只向其中一个实例上报，离线检查只由 leader 按数据库中各实例合并后的记录执行。
+
+// heartbeatConfig 主机心跳配置
+type heartbeatConfig struct {
+	Enable      bool    `json:"enable"`       // 是否启用离线告警
+	Multiple    float64 `json:"multiple"`     // 超过通常上报间隔的倍数视为离线，默认 3
+	MinInterval string  `json:"min_interval"` // 离线判定的最短时间，默认 1m
+}
+
+// HostHeartbeat 主机上报记录，Measurement 为空表示主机整体
+type HostHeartbeat struct {
+	ID              uint      `gorm:"primaryKey;autoIncrement" json:"id"`                                          // 数据库主键
+	Host            string    `gorm:"type:varchar(100);not null;uniqueIndex:idx_heartbeat_host" json:"host"`       // 主机名
+	Measurement     string    `gorm:"type:varchar(50);not null;uniqueIndex:idx_heartbeat_host" json:"measurement"` // 测量名称
+	FirstSeen       time.Time `gorm:"not null" json:"first_seen"`                                                  // 首次上报时间
+	LastSeen        time.Time `gorm:"not null;index" json:"last_seen"`                                             // 最后上报时间
+	IntervalSeconds float64   `gorm:"type:decimal(12,3);not null" json:"interval_seconds"`                         // 估算的上报间隔（秒）
+	Samples         int64     `gorm:"not null" json:"samples"`                                                     // 累计样本数
+	UpdatedAt       time.Time `json:"updated_at"`                                                                  // 记录更新时间
+}
+
+// TableName 指定 HostHeartbeat 的表名
+func (HostHeartbeat) TableName() string {
+	return "host_heartbeats"
+}
+
+// heartbeatKey 心跳记录的键
+type heartbeatKey struct {
+	Host        string
+	Measurement string
+}
+
+// heartbeatEntry 内存中的心跳记录
+type heartbeatEntry struct {
+	record HostHeartbeat
+	dirty  bool // 是否有未写入数据库的变化
+	down   bool // 是否已触发离线告警
+}
+
+// heartbeatRegistry 保存所有主机的心跳
+type heartbeatRegistry struct {
+	mu      sync.Mutex
+	entries map[heartbeatKey]*heartbeatEntry
+}
+
+var heartbeats = &heartbeatRegistry{entries: make(map[heartbeatKey]*heartbeatEntry)}
+
+// 间隔估算: 同一批次内的样本间隔小于 minHeartbeatGap 时不计入，超过离线判定时间的中断不计入，其余按 EWMA 平滑
+const (
+	minHeartbeatGap = time.Second
+	heartbeatAlpha  = 0.2
+)
+
+// heartbeatFlushSlack 其他实例每分钟写入一次心跳，数据库中的最后上报时间最多落后这么久，离线判定时间需加上
+const heartbeatFlushSlack = time.Minute
+
+// initHeartbeats 从数据库加载心跳记录，需在 initAlerting 之后调用
+func initHeartbeats() error {
+	var records []HostHeartbeat
+	if err := db.Find(&records).Error; err != nil {
+		return fmt.Errorf("读取主机心跳失败: %v", err)
+	}
+
+	heartbeats.mu.Lock()
+	defer heartbeats.mu.Unlock()
+	for _, r := range records {
+		e := &heartbeatEntry{record: r}
+		// 重启前已离线的主机，恢复上报时需要解除告警
+		if r.Measurement == "" {
+			e.down = alerts.isActive(hostDownSignal(r.Host, 0))
+		}
+		heartbeats.entries[heartbeatKey{Host: r.Host, Measurement: r.Measurement}] = e
+	}
+	return nil
+}
+
+// touch 记录一次上报，同时更新主机整体和对应 measurement 的记录
+func (h *heartbeatRegistry) touch(host, measurement string, now time.Time) {
+	if host == "" {
+		return
+	}
+
+	h.mu.Lock()
+	h.touchLocked(heartbeatKey{Host: host, Measurement: measurement}, now)
+	hostEntry := h.touchLocked(heartbeatKey{Host: host}, now)
+	recovered := hostEntry.down
+	hostEntry.down = false
+	h.mu.Unlock()
+
+	// 主机重新上报，恢复离线告警
+	if recovered {
+		alerts.update(hostDownSignal(host, 0), false, now)
+	}
+}
+
+// touchLocked 更新单条心跳记录，调用方需持有锁
+func (h *heartbeatRegistry) touchLocked(key heartbeatKey, now time.Time) *heartbeatEntry {
+	e, ok := h.entries[key]
+	if !ok {
+		e = &heartbeatEntry{record: HostHeartbeat{
+			Host:        key.Host,
+			Measurement: key.Measurement,
+			FirstSeen:   now,
+			LastSeen:    now,
+		}}
+		h.entries[key] = e
+	}
+
+	// 中断不是上报周期，计入会拉长之后的离线判定时间
+	if gap := now.Sub(e.record.LastSeen); gap >= minHeartbeatGap {
+		switch {
+		case e.record.IntervalSeconds == 0:
+			e.record.IntervalSeconds = gap.Seconds()
+		case gap < absenceThreshold(e.record.IntervalSeconds):
+			e.record.IntervalSeconds = heartbeatAlpha*gap.Seconds() + (1-heartbeatAlpha)*e.record.IntervalSeconds
+		}
+	}
+	e.record.LastSeen = now
+	e.record.Samples++
+	e.dirty = true
+	return e
+}
+
+// hostDownSignal 构造主机离线告警
+func hostDownSignal(host string, silence time.Duration) alertSignal {
+	return alertSignal{
+		Rule:     "host_down",
+		Severity: "critical",
+		Summary:  fmt.Sprintf("主机 %s 已 %s 未上报数据", host, silence.Round(time.Second)),
+		Labels:   map[string]string{"host": host},
+		Value:    silence.Seconds(),
+	}
+}
+
+// absenceThreshold 计算主机的离线判定时间
+func absenceThreshold(intervalSeconds float64) time.Duration {
+	multiple := conf().Heartbeat.Multiple
+	if multiple <= 0 {
+		multiple = 3
+	}
+	minInterval := time.Minute
+	if d, err := time.ParseDuration(conf().Heartbeat.MinInterval); err == nil && d > 0 {
+		minInterval = d
+	}
+	threshold := time.Duration(intervalSeconds * multiple * float64(time.Second))
+	if threshold < minInterval {
+		threshold = minInterval
+	}
+	return threshold
+}
+
+// checkHostAbsence 定时任务，按数据库中所有实例合并后的心跳检查长时间未上报的主机，并恢复重新上报的主机；
+// 本实例尚未写入数据库的上报同样计入
+func checkHostAbsence(ctx context.Context) error {
+	var records []HostHeartbeat
+	if err := db.WithContext(ctx).Where("measurement = ?", "").Find(&records).Error; err != nil {
+		return fmt.Errorf("读取主机心跳失败: %v", err)
+	}
+	now := time.Now()
+
+	type hostState struct {
+		sig  alertSignal
+		down bool
+	}
+	states := make([]hostState, 0, len(records))
+	heartbeats.mu.Lock()
+	for _, r := range records {
+		lastSeen := r.LastSeen
+		e, ok := heartbeats.entries[heartbeatKey{Host: r.Host}]
+		if ok && e.record.LastSeen.After(lastSeen) {
+			lastSeen = e.record.LastSeen
+		}
+		silence := now.Sub(lastSeen)
+		down := silence > absenceThreshold(r.IntervalSeconds)+heartbeatFlushSlack
+		if ok {
+			e.down = down
+		}
+		states = append(states, hostState{sig: hostDownSignal(r.Host, silence), down: down})
+	}
+	heartbeats.mu.Unlock()
+
+	for _, st := range states {
+		if st.down == alerts.isActive(st.sig) {
+			continue
+		}
+		if st.down {
+			alertLog.Warn("主机超时未上报数据", "host", st.sig.Labels["host"])
+		}
+		alerts.update(st.sig, st.down, now)
+	}
+	return nil
+}
+
+// heartbeatUpserts 写入心跳时与其他实例写入的记录合并：最后上报时间和样本数取较大值，首次上报时间取较小值，
+// 上报间隔使用最后上报时间较新的一方。MySQL 按顺序执行赋值，interval_seconds 需在 last_seen 之前更新
+var heartbeatUpserts = clause.Set{
+	{Column: clause.Column{Name: "interval_seconds"}, Value: gorm.Expr("IF(VALUES(last_seen) >= last_seen, VALUES(interval_seconds), interval_seconds)")},
+	{Column: clause.Column{Name: "last_seen"}, Value: gorm.Expr("GREATEST(last_seen, VALUES(last_seen))")},
+	{Column: clause.Column{Name: "first_seen"}, Value: gorm.Expr("LEAST(first_seen, VALUES(first_seen))")},
+	{Column: clause.Column{Name: "samples"}, Value: gorm.Expr("GREATEST(samples, VALUES(samples))")},
+	{Column: clause.Column{Name: "updated_at"}, Value: gorm.Expr("VALUES(updated_at)")},
+}
+
+// flushHeartbeats 定时任务，将有变化的心跳记录合并写入数据库
+func flushHeartbeats() error {
+	heartbeats.mu.Lock()
+	var pending []*heartbeatEntry
+	for _, e := range heartbeats.entries {
+		if e.dirty {
+			pending = append(pending, e)
+		}
+	}
+	records := make([]HostHeartbeat, len(pending))
+	for i, e := range pending {
+		records[i] = e.record
+		// 按 idx_heartbeat_host 合并，同一主机的记录可能由其他实例插入
+		records[i].ID = 0
+		e.dirty = false
+	}
+	heartbeats.mu.Unlock()
+
+	var lastErr error
+	for i := range records {
+		err := db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "host"}, {Name: "measurement"}},
+			DoUpdates: heartbeatUpserts,
+		}).Create(&records[i]).Error
+		if err != nil {
+			alertLog.Error("保存主机心跳失败", "host", records[i].Host, "measurement", records[i].Measurement, "err", err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// hostInventory 主机清单中的一项
+type hostInventory struct {
+	Host            string          `json:"host"`
+	FirstSeen       time.Time       `json:"first_seen"`
+	LastSeen        time.Time       `json:"last_seen"`
+	IntervalSeconds float64         `json:"interval_seconds"`
+	Down            bool            `json:"down"`
+	Measurements    []HostHeartbeat `json:"measurements"`
+}
+
+// inventory 返回按主机名排序的主机清单
+func (h *heartbeatRegistry) inventory() []hostInventory {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hosts := make(map[string]*hostInventory)
+	for key, e := range h.entries {
+		inv, ok := hosts[key.Host]
+		if !ok {
+			inv = &hostInventory{Host: key.Host}
+			hosts[key.Host] = inv
+		}
+		if key.Measurement == "" {
+			inv.FirstSeen = e.record.FirstSeen
+			inv.LastSeen = e.record.LastSeen
+			inv.IntervalSeconds = e.record.IntervalSeconds
+			inv.Down = e.down
+			continue
+		}
+		inv.Measurements = append(inv.Measurements, e.record)
+	}
+
+	result := make([]hostInventory, 0, len(hosts))
+	for _, inv := range hosts {
+		sort.Slice(inv.Measurements, func(i, j int) bool {
+			return inv.Measurements[i].Measurement < inv.Measurements[j].Measurement
+		})
+		result = append(result, *inv)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Host < result[j].Host })
+	return result
+}
+
+// handleHosts 返回主机清单，参数 host 可只查询单个主机
+func handleHosts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "只接受 GET 请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	result := heartbeats.inventory()
+	if host := r.URL.Query().Get("host"); host != "" {
+		filtered := result[:0]
+		for _, inv := range result {
+			if inv.Host == host {
+				filtered = append(filtered, inv)
+			}
+		}
+		result = filtered
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 任务管理
+// TaskRun 中的定时任务都在这里注册，可以通过 API 或命令行立即执行，collectDisposeHour 还可以指定时间段重新统计。
+// 同一任务同时只执行一次，上次执行尚未结束时跳过本次执行（手动执行返回 409）。
+// 每次执行记录到 job_runs 表（开始、结束时间、状态、处理条数和错误）；每分钟执行的任务只记录失败和手动执行。
+// 全局任务（singleton）执行期间持有数据库中的 job:<任务名> 租约，服务和命令行进程、多个实例之间都不会重叠执行；
+// 启用 cluster 时全局任务只由 leader 按计划执行，见 cluster.go。
+
+// 任务执行状态
+const (
+	jobStatusRunning = "running"
+	jobStatusSuccess = "success"
+	jobStatusFailed  = "failed"
+)
+
+// 任务触发方式
+const (
+	jobTriggerSchedule = "schedule"
+	jobTriggerManual   = "manual"
+)
+
+// errJobRunning 任务上次执行尚未结束
+var errJobRunning = errors.New("任务正在执行")
+
+// JobRun 任务执行记录
+type JobRun struct {
+	ID          uint       `gorm:"primaryKey;autoIncrement" json:"id"`              // 数据库主键
+	Job         string     `gorm:"type:varchar(50);not null;index" json:"job"`      // 任务名称
+	TriggerType string     `gorm:"type:varchar(20);not null" json:"trigger_type"`   // schedule 或 manual
+	TriggeredBy string     `gorm:"type:varchar(100)" json:"triggered_by,omitempty"` // 手动执行的调用方
+	Instance    string     `gorm:"type:varchar(255);index" json:"instance"`         // 执行任务的实例
+	WindowFrom  *time.Time `json:"window_from,omitempty"`                           // 指定的时间段起点
+	WindowTo    *time.Time `json:"window_to,omitempty"`                             // 指定的时间段终点（不包含）
+	Status      string     `gorm:"type:varchar(20);not null;index" json:"status"`   // running、success、failed
+	Rows        int64      `gorm:"not null;default:0" json:"rows"`                  // 处理的数据条数
+	Error       string     `gorm:"type:text" json:"error,omitempty"`                // 失败原因
+	StartedAt   time.Time  `gorm:"not null;index" json:"started_at"`                // 开始时间
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`                           // 结束时间
+}
+
+// TableName 指定 JobRun 的表名
+func (JobRun) TableName() string {
+	return "job_runs"
+}
+
+// window 执行时指定的时间段
+func (r *JobRun) window() jobWindow {
+	var w jobWindow
+	if r.WindowFrom != nil && r.WindowTo != nil {
+		w.From, w.To = *r.WindowFrom, *r.WindowTo
+	}
+	return w
+}
+
+// jobWindow 手动执行时指定的时间段 [From, To)，零值表示使用任务默认的时间段
+type jobWindow struct {
+	From time.Time
+	To   time.Time
+}
+
+func (w jobWindow) isZero() bool {
+	return w.From.IsZero() && w.To.IsZero()
+}
+
+// jobDef 已注册的任务
+type jobDef struct {
+	name string
+	// 返回处理的数据条数；全局任务失去 job:<任务名> 租约时 ctx 被取消
+	run     func(ctx context.Context, w jobWindow) (int64, error)
+	window  bool // 是否支持指定时间段
+	history bool // 是否记录每次执行，为 false 时只记录失败和手动执行
+	// 是否为全局任务，多实例部署时只由 leader 按计划执行；其余任务只处理本实例内存中的状态，每个实例都执行
+	singleton bool
+	// 是否依赖服务进程内存中的状态（告警、心跳、静默缓存等），命令行 jobs run 不能执行
+	serveOnly bool
+
+	mu      sync.Mutex // 防止同一任务重叠执行
+	running atomic.Bool
+}
+
+// countJob 不支持指定时间段、返回处理条数的任务
+func countJob(fn func(ctx context.Context) (int64, error)) func(context.Context, jobWindow) (int64, error) {
+	return func(ctx context.Context, _ jobWindow) (int64, error) { return fn(ctx) }
+}
+
+// contextJob 不支持指定时间段、不统计处理条数、使用 ctx 写入数据的全局任务
+func contextJob(fn func(ctx context.Context) error) func(context.Context, jobWindow) (int64, error) {
+	return func(ctx context.Context, _ jobWindow) (int64, error) { return 0, fn(ctx) }
+}
+
+// plainJob 不支持指定时间段、不统计处理条数、只处理本实例状态的任务
+func plainJob(fn func() error) func(context.Context, jobWindow) (int64, error) {
+	return func(context.Context, jobWindow) (int64, error) { return 0, fn() }
+}
+
+// jobDefs 所有任务，在 init 中赋值以避免初始化循环
+var jobDefs []*jobDef
+
+func init() {
+	jobDefs = []*jobDef{
+		{name: "collectDisposeHour", run: collectDisposeHour, window: true, history: true, singleton: true},
+		{name: "clearDisk", run: countJob(clearDisk), history: true, singleton: true},
+		{name: "clearCpu", run: countJob(clearCpu), history: true, singleton: true},
+		{name: "clearMem", run: countJob(clearMem), history: true, singleton: true},
+		{name: "clearNet5Min", run: countJob(clearNet5Min), history: true, singleton: true},
+		{name: "clearJobRuns", run: countJob(clearJobRuns), history: true, singleton: true},
+		{name: "checkTrafficQuotas", run: contextJob(checkTrafficQuotas), history: true, singleton: true},
+		{name: "forecastDiskFull", run: contextJob(forecastDiskFull), history: true, singleton: true},
+		{name: "detectAnomalies", run: contextJob(detectAnomalies), history: true, singleton: true},
+		{name: "evaluateAlerts", run: plainJob(evaluateAlerts), serveOnly: true},
+		{name: "flushHeartbeats", run: plainJob(flushHeartbeats), serveOnly: true},
+		{name: "refreshSilences", run: plainJob(refreshSilences), serveOnly: true},
+		{name: "checkHostAbsence", run: contextJob(checkHostAbsence), singleton: true, serveOnly: true},
+		{name: "storeSelfMetrics", run: plainJob(storeSelfMetrics), serveOnly: true},
+		{name: "flushCPUCores", run: plainJob(flushCPUCores), serveOnly: true},
+	}
+}
+
+// findJob 按名称查找任务
+func findJob(name string) *jobDef {
+	for _, j := range jobDefs {
+		if j.name == name {
+			return j
+		}
+	}
+	return nil
+}
+
+// manualJobs 通过 API 手动执行、尚未结束的任务，退出时等待
+var manualJobs sync.WaitGroup
+
+// tryStart 获取任务锁并记录开始执行，任务正在执行时返回 errJobRunning
+func (j *jobDef) tryStart(w jobWindow, trigger, by string) (*JobRun, error) {
+	if !w.isZero() {
+		if !j.window {
+			return nil, fmt.Errorf("任务 %s 不支持指定时间段", j.name)
+		}
+		if !w.From.Before(w.To) {
+			return nil, fmt.Errorf("开始时间必须早于结束时间")
+		}
+	}
+	if !j.mu.TryLock() {
+		jobSkipped.inc(j.name)
+		cronLog.Warn("任务上次执行尚未结束，跳过本次执行", "job", j.name, "trigger", trigger)
+		return nil, errJobRunning
+	}
+	if j.singleton {
+		ok, err := tryAcquireLease(j.jobLease(), leaseTTL())
+		if err != nil || !ok {
+			j.mu.Unlock()
+			if err != nil {
+				return nil, fmt.Errorf("获取任务租约失败: %v", err)
+			}
+			jobSkipped.inc(j.name)
+			cronLog.Warn("任务正在其他进程或实例执行，跳过本次执行", "job", j.name, "trigger", trigger)
+			return nil, errJobRunning
+		}
+	}
+	j.running.Store(true)
+
+	run := &JobRun{
+		Job:         j.name,
+		TriggerType: trigger,
+		TriggeredBy: by,
+		Instance:    instanceID(),
+		Status:      jobStatusRunning,
+		StartedAt:   time.Now(),
+	}
+	if !w.isZero() {
+		run.WindowFrom, run.WindowTo = &w.From, &w.To
+	}
+	if j.history || trigger == jobTriggerManual {
+		if err := db.Create(run).Error; err != nil {
+			cronLog.Warn("保存任务执行记录失败", "job", j.name, "err", err)
+		}
+	}
+	return run, nil
+}
+
+// execute 执行任务并记录结果，完成后释放任务锁；任务 panic 时同样计为失败并恢复，避免进程退出
+func (j *jobDef) execute(run *JobRun) {
+	defer j.mu.Unlock()
+	defer j.running.Store(false)
+
+	var (
+		rows int64
+		err  error
+	)
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		if j.singleton {
+			leaseErr := holdLease(j.jobLease(), leaseTTL(), func(ctx context.Context) { rows, err = j.run(ctx, run.window()) })
+			if leaseErr != nil {
+				err = leaseErr
+			}
+			return
+		}
+		rows, err = j.run(context.Background(), run.window())
+	}()
+
+	finished := time.Now()
+	jobDuration.observe(finished.Sub(run.StartedAt).Seconds(), j.name)
+	run.Rows = rows
+	run.FinishedAt = &finished
+	if err != nil {
+		run.Status = jobStatusFailed
+		run.Error = err.Error()
+		jobFailures.inc(j.name)
+		cronLog.Error("定时任务失败", "job", j.name, "trigger", run.TriggerType, "err", err)
+	} else {
+		run.Status = jobStatusSuccess
+		jobLastSuccess.set(float64(finished.Unix()), j.name)
+	}
+
+	var saveErr error
+	switch {
+	case run.ID != 0:
+		saveErr = db.Save(run).Error
+	case run.Status == jobStatusFailed:
+		saveErr = db.Create(run).Error
+	}
+	if saveErr != nil {
+		cronLog.Warn("保存任务执行记录失败", "job", j.name, "err", saveErr)
+	}
+}
+
+// jobLease 任务执行期间持有的租约名称
+func (j *jobDef) jobLease() string {
+	return "job:" + j.name
+}
+
+// runNow 立即同步执行任务
+func (j *jobDef) runNow(w jobWindow, trigger, by string) (*JobRun, error) {
+	run, err := j.tryStart(w, trigger, by)
+	if err != nil {
+		return nil, err
+	}
+	j.execute(run)
+	return run, nil
+}
+
+// cronJob 按计划执行的任务，实现 cron.Job
+type cronJob struct {
+	name string
+	job  *jobDef
+}
+
+// scheduledJob 返回已注册任务的 cron.Job
+func scheduledJob(name string) *cronJob {
+	return &cronJob{name: name, job: findJob(name)}
+}
+
+// Run 实现 cron.Job，上次执行尚未结束时跳过；多实例部署时非 leader 不执行全局任务
+func (c *cronJob) Run() {
+	markSchedulerTick()
+	if c.job.singleton && !isLeader() {
+		return
+	}
+	_, _ = c.job.runNow(jobWindow{}, jobTriggerSchedule, "")
+}
+
+// initJobs 将本实例上次进程退出时未完成的执行记录标记为失败
+func initJobs() error {
+	now := time.Now()
+	err := db.Model(&JobRun{}).Where("status = ? AND instance = ?", jobStatusRunning, instanceID()).Updates(map[string]interface{}{
+		"status":      jobStatusFailed,
+		"error":       "进程退出时任务未完成",
+		"finished_at": now,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("更新未完成的任务记录失败: %v", err)
+	}
+	return nil
+}
+
+// jobInfo /api/jobs 的响应
+type jobInfo struct {
+	Name    string  `json:"name"`
+	Window  bool    `json:"window"`
+	Running bool    `json:"running"`
+	LastRun *JobRun `json:"last_run,omitempty"`
+}
+
+// listJobs 返回所有任务及最近一次执行记录
+func listJobs() ([]jobInfo, error) {
+	jobs := make([]jobInfo, 0, len(jobDefs))
+	for _, j := range jobDefs {
+		info := jobInfo{Name: j.name, Window: j.window, Running: j.running.Load()}
+		var runs []JobRun
+		if err := db.Where("job = ?", j.name).Order("id DESC").Limit(1).Find(&runs).Error; err != nil {
+			return nil, err
+		}
+		if len(runs) > 0 {
+			info.LastRun = &runs[0]
+		}
+		jobs = append(jobs, info)
+	}
+	return jobs, nil
+}
+
+// handleJobs 查询所有任务
+func handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "只接受 GET 请求", http.StatusMethodNotAllowed)
+		return
+	}
+	jobs, err := listJobs()
+	if err != nil {
+		http.Error(w, "查询任务失败", http.StatusInternalServerError)
+		apiLog.Error("查询任务失败", "err", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+// handleJobRuns 查询任务执行记录，参数: job、status、limit
+func handleJobRuns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "只接受 GET 请求", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	tx := db.Order("id DESC").Limit(queryLimit(r))
+	if job := q.Get("job"); job != "" {
+		tx = tx.Where("job = ?", job)
+	}
+	if status := q.Get("status"); status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+	var runs []JobRun
+	if err := tx.Find(&runs).Error; err != nil {
+		http.Error(w, "查询任务执行记录失败", http.StatusInternalServerError)
+		apiLog.Error("查询任务执行记录失败", "err", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, runs)
+}
+
+// jobRunRequest 手动执行任务的请求
+type jobRunRequest struct {
+	Job  string `json:"job"`
+	From string `json:"from"` // 可选，时间段起点
+	To   string `json:"to"`   // 可选，时间段终点（不包含）
+}
+
+// window 解析请求中的时间段
+func (req jobRunRequest) window() (jobWindow, error) {
+	var w jobWindow
+	if req.From == "" && req.To == "" {
+		return w, nil
+	}
+	if req.From == "" || req.To == "" {
+		return w, fmt.Errorf("from 和 to 需要同时指定")
+	}
+	var err error
+	if w.From, err = parseCLITime(req.From); err != nil {
+		return w, err
+	}
+	if w.To, err = parseCLITime(req.To); err != nil {
+		return w, err
+	}
+	return w, nil
+}
+
+// handleJobRun 手动执行任务，任务在后台执行，立即返回 202 和执行记录
+func handleJobRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "只接受 POST 请求", http.StatusMethodNotAllowed)
+		return
+	}
+	var req jobRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "无法解析请求体", http.StatusBadRequest)
+		return
+	}
+	j := findJob(req.Job)
+	if j == nil {
+		http.Error(w, "未知的任务", http.StatusNotFound)
+		return
+	}
+	window, err := req.window()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	by := ""
+	if p := principalFrom(r.Context()); p != nil {
+		by = p.Name
+	}
+	run, err := j.tryStart(window, jobTriggerManual, by)
+	if errors.Is(err, errJobRunning) {
+		http.Error(w, "任务正在执行", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	apiLog.Info("手动执行任务", "job", j.name, "by", by, "from", req.From, "to", req.To)
+
+	manualJobs.Add(1)
+	go func() {
+		defer manualJobs.Done()
+		j.execute(run)
+	}()
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+// runJobsCommand 处理 jobs 子命令，run 在当前进程中同步执行
+//
+//	jobs list
+//	jobs run <任务> [-from 时间 -to 时间]
+//	jobs history [-job 任务] [-limit 20]
+func runJobsCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("用法: jobs list|run|history")
+	}
+
+	switch args[0] {
+	case "list":
+		jobs, err := listJobs()
+		if err != nil {
+			return err
+		}
+		for _, j := range jobs {
+			last := "-"
+			if j.LastRun != nil {
+				last = fmt.Sprintf("%s %s", j.LastRun.StartedAt.Format(time.DateTime), j.LastRun.Status)
+			}
+			fmt.Printf("%-20s 时间段:%-5v 最近执行: %s\n", j.Name, j.Window, last)
+		}
+		return nil
+
+	case "run":
+		if len(args) < 2 {
+			return fmt.Errorf("用法: jobs run <任务> [-from 时间 -to 时间]")
+		}
+		j := findJob(args[1])
+		if j == nil {
+			return fmt.Errorf("未知的任务: %s", args[1])
+		}
+		if j.serveOnly {
+			return fmt.Errorf("任务 %s 处理服务进程内存中的状态，只能在服务中执行（POST /api/jobs/run）", j.name)
+		}
+		fs := flag.NewFlagSet("jobs run", flag.ContinueOnError)
+		var req jobRunRequest
+		fs.StringVar(&req.From, "from", "", "时间段起点（包含）")
+		fs.StringVar(&req.To, "to", "", "时间段终点（不包含）")
+		if err := fs.Parse(args[2:]); err != nil {
+			return err
+		}
+		window, err := req.window()
+		if err != nil {
+			return err
+		}
+		run, err := j.runNow(window, jobTriggerManual, "cli:"+os.Getenv("USER"))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("任务 %s %s，处理 %d 条，耗时 %s\n", j.name, run.Status, run.Rows, run.FinishedAt.Sub(run.StartedAt).Round(time.Millisecond))
+		if run.Status == jobStatusFailed {
+			return errors.New(run.Error)
+		}
+		return nil
+
+	case "history":
+		fs := flag.NewFlagSet("jobs history", flag.ContinueOnError)
+		job := fs.String("job", "", "只显示指定任务")
+		limit := fs.Int("limit", 20, "显示条数")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		tx := db.Order("id DESC").Limit(*limit)
+		if *job != "" {
+			tx = tx.Where("job = ?", *job)
+		}
+		var runs []JobRun
+		if err := tx.Find(&runs).Error; err != nil {
+			return err
+		}
+		for _, r := range runs {
+			elapsed := "-"
+			if r.FinishedAt != nil {
+				elapsed = r.FinishedAt.Sub(r.StartedAt).Round(time.Millisecond).String()
+			}
+			fmt.Printf("#%d\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", r.ID, r.Job, r.StartedAt.Format(time.DateTime), r.TriggerType, r.Status, r.Rows, elapsed, r.Error)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("未知的子命令: %s", args[0])
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// 优雅退出
+// 收到 SIGINT/SIGTERM 后按顺序：停止接收新连接并等待处理中的请求、停止定时任务并等待正在执行的任务（包括手动执行的任务）、
+// 释放 leader 租约、保存主机心跳和每核 CPU 汇总、发送未发出的告警通知，最后关闭数据库连接池。
+// 所有步骤共用一个截止时间（shutdown_timeout，默认 10s），需小于 moc.service 中的 TimeoutStopSec。
+
+// defaultShutdownTimeout 默认退出截止时间
+const defaultShutdownTimeout = 10 * time.Second
+
+// shutdownTimeout 解析退出截止时间配置
+func shutdownTimeout() time.Duration {
+	if d, err := time.ParseDuration(conf().ShutdownTimeout); err == nil && d > 0 {
+		return d
+	}
+	return defaultShutdownTimeout
+}
+
+// shutdown 按顺序停止各组件，超过 ctx 截止时间的步骤不再等待
+func shutdown(ctx context.Context, srv *http.Server) {
+	start := time.Now()
+	if err := sdNotify("STOPPING=1"); err != nil {
+		serverLog.Warn("发送 systemd 退出通知失败", "err", err)
+	}
+
+	// 1. 停止接收新连接，等待处理中的请求完成
+	if err := srv.Shutdown(ctx); err != nil {
+		serverLog.Warn("等待 HTTP 请求完成超时", "err", err)
+	}
+
+	// 2. 停止调度，等待正在执行的定时任务和手动执行的任务
+	jobsDone := make(chan struct{})
+	go func() {
+		if c := scheduler.Load(); c != nil {
+			<-c.Stop().Done()
+		}
+		manualJobs.Wait()
+		close(jobsDone)
+	}()
+	select {
+	case <-jobsDone:
+	case <-ctx.Done():
+		serverLog.Warn("等待定时任务完成超时", "err", ctx.Err())
+	}
+	// 多实例部署时释放 leader 租约，其他实例无需等待租约过期即可接管
+	resignLeader()
+
+	// 3. 保存内存中的主机心跳和每核 CPU 汇总
+	if err := flushHeartbeats(); err != nil {
+		serverLog.Error("保存主机心跳失败", "err", err)
+	}
+	if err := flushCPUCores(); err != nil {
+		serverLog.Error("保存每核 CPU 汇总失败", "err", err)
+	}
+
+	// 4. 发送通知队列中剩余的告警
+	if d := dispatcher.Load(); d != nil {
+		if err := d.stop(ctx); err != nil {
+			serverLog.Warn("等待告警通知发送超时", "err", err)
+		}
+	}
+
+	// 5. 关闭数据库连接池
+	if sqlDB, err := db.DB(); err == nil {
+		if err := sqlDB.Close(); err != nil {
+			serverLog.Error("关闭数据库连接失败", "err", err)
+		}
+	}
+	serverLog.Info("服务已退出", "elapsed", time.Since(start).Round(time.Millisecond))
+}
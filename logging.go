@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// 结构化日志
+// 基于 log/slog，日志级别使用 log_level（debug、info、warn、error、silent），输出格式为 text 或 json。
+// 各模块使用带 component 属性的日志器（ingest、store、cron、alert、notify、api、server），
+// info 和 warn 级别相同内容的日志在采样窗口内超过 sample_burst 条后丢弃，下一个窗口的第一条日志带上丢弃的条数；
+// error 日志通常带有任务、主机等区分信息，全部输出。
+// 收到的每条指标只在 debug 级别且开启 debug_payloads 时输出。
+
+// logConfig 日志配置
+type logConfig struct {
+	Format         string `json:"format"`          // text（默认）或 json
+	DebugPayloads  bool   `json:"debug_payloads"`  // 在 debug 级别输出收到的每条指标和写入的数据，默认关闭
+	SampleInterval string `json:"sample_interval"` // 相同日志的采样窗口，默认 10s，设置为 0 关闭采样
+	SampleBurst    int    `json:"sample_burst"`    // 每个窗口内相同日志最多输出的条数，默认 10
+}
+
+// logLevel 当前日志级别，可在运行时修改
+var logLevel = new(slog.LevelVar)
+
+// logRoot 所有日志器共用的 handler，initLogging 替换其中的实际输出
+var logRoot = newSwapHandler(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+
+// 各模块的日志器
+var (
+	ingestLog = componentLogger("ingest")
+	storeLog  = componentLogger("store")
+	cronLog   = componentLogger("cron")
+	alertLog  = componentLogger("alert")
+	notifyLog = componentLogger("notify")
+	apiLog    = componentLogger("api")
+	serverLog = componentLogger("server")
+)
+
+// componentLogger 创建带 component 属性的日志器
+func componentLogger(name string) *slog.Logger {
+	return slog.New(logRoot).With("component", name)
+}
+
+// parseSlogLevel 解析日志级别，silent 时只保留高于 error 的日志（即不输出）
+func parseSlogLevel(level string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	case "silent":
+		return slog.LevelError + 4
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// initLogging 根据配置创建日志输出并替换所有日志器的 handler
+func initLogging() error {
+	logLevel.Set(parseSlogLevel(conf().LogLevel))
+
+	var out io.Writer = os.Stdout
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var h slog.Handler
+	switch conf().Log.Format {
+	case "", "text":
+		h = slog.NewTextHandler(out, opts)
+	case "json":
+		h = slog.NewJSONHandler(out, opts)
+	default:
+		return fmt.Errorf("不支持的日志格式: %q", conf().Log.Format)
+	}
+
+	interval := 10 * time.Second
+	if conf().Log.SampleInterval != "" {
+		d, err := time.ParseDuration(conf().Log.SampleInterval)
+		if err != nil {
+			return fmt.Errorf("无法解析 sample_interval: %v", err)
+		}
+		interval = d
+	}
+	burst := conf().Log.SampleBurst
+	if burst <= 0 {
+		burst = 10
+	}
+	if interval > 0 {
+		h = newSamplingHandler(h, interval, burst)
+	}
+
+	logRoot.swap(h)
+	slog.SetDefault(slog.New(logRoot))
+	return nil
+}
+
+// fatal 输出错误日志后退出进程
+func fatal(l *slog.Logger, msg string, args ...interface{}) {
+	l.Error(msg, args...)
+	os.Exit(1)
+}
+
+// debugPayload 在开启 debug_payloads 时输出一条指标的完整内容
+func debugPayload(metric *TelegrafJson) {
+	if !conf().Log.DebugPayloads {
+		return
+	}
+	ingestLog.Debug("收到指标",
+		"measurement", metric.Name,
+		"tags", metric.Tags,
+		"fields", metric.Fields,
+		"timestamp", metric.Timestamp)
+}
+
+// swapHandler 可替换实际输出的 handler，WithAttrs/WithGroup 派生的 handler 共享同一个输出
+type swapHandler struct {
+	current *atomic.Pointer[slog.Handler]
+	derive  func(slog.Handler) slog.Handler // 派生时追加的属性和分组，为空表示根 handler
+	cache   *atomic.Pointer[derivedHandler]
+}
+
+// derivedHandler 缓存 base 对应的派生 handler，base 变化后重新派生
+type derivedHandler struct {
+	base    slog.Handler
+	derived slog.Handler
+}
+
+func newSwapHandler(h slog.Handler) *swapHandler {
+	s := &swapHandler{current: new(atomic.Pointer[slog.Handler]), cache: new(atomic.Pointer[derivedHandler])}
+	s.current.Store(&h)
+	return s
+}
+
+// swap 替换实际输出
+func (s *swapHandler) swap(h slog.Handler) {
+	s.current.Store(&h)
+}
+
+// handler 返回当前输出派生出的 handler
+func (s *swapHandler) handler() slog.Handler {
+	base := *s.current.Load()
+	if s.derive == nil {
+		return base
+	}
+	if c := s.cache.Load(); c != nil && c.base == base {
+		return c.derived
+	}
+	derived := s.derive(base)
+	s.cache.Store(&derivedHandler{base: base, derived: derived})
+	return derived
+}
+
+func (s *swapHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return (*s.current.Load()).Enabled(ctx, level)
+}
+
+func (s *swapHandler) Handle(ctx context.Context, r slog.Record) error {
+	return s.handler().Handle(ctx, r)
+}
+
+func (s *swapHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return s.with(func(h slog.Handler) slog.Handler { return h.WithAttrs(attrs) })
+}
+
+func (s *swapHandler) WithGroup(name string) slog.Handler {
+	return s.with(func(h slog.Handler) slog.Handler { return h.WithGroup(name) })
+}
+
+func (s *swapHandler) with(f func(slog.Handler) slog.Handler) *swapHandler {
+	parent := s.derive
+	derive := f
+	if parent != nil {
+		derive = func(h slog.Handler) slog.Handler { return f(parent(h)) }
+	}
+	return &swapHandler{current: s.current, derive: derive, cache: new(atomic.Pointer[derivedHandler])}
+}
+
+// samplingHandler 对相同级别、相同内容的日志采样，debug 日志需要显式开启，error 日志不能丢失，都不采样
+type samplingHandler struct {
+	next     slog.Handler
+	interval time.Duration
+	burst    int
+	state    *samplingState
+}
+
+// samplingState 各日志内容的采样窗口，派生的 handler 共享
+type samplingState struct {
+	mu      sync.Mutex
+	windows map[string]*sampleWindow
+}
+
+// sampleWindow 一个采样窗口内的计数
+type sampleWindow struct {
+	start   time.Time
+	count   int
+	dropped int
+}
+
+// maxSampleWindows 采样窗口数量上限，超过时清理已过期的窗口
+const maxSampleWindows = 1000
+
+func newSamplingHandler(next slog.Handler, interval time.Duration, burst int) *samplingHandler {
+	return &samplingHandler{
+		next:     next,
+		interval: interval,
+		burst:    burst,
+		state:    &samplingState{windows: make(map[string]*sampleWindow)},
+	}
+}
+
+func (h *samplingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *samplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelInfo || r.Level >= slog.LevelError {
+		return h.next.Handle(ctx, r)
+	}
+	key := r.Level.String() + "|" + r.Message
+	st := h.state
+	st.mu.Lock()
+	w, ok := st.windows[key]
+	if !ok || r.Time.Sub(w.start) >= h.interval {
+		if ok && w.dropped > 0 {
+			r.AddAttrs(slog.Int("sampled_dropped", w.dropped))
+		}
+		if len(st.windows) >= maxSampleWindows {
+			for k, old := range st.windows {
+				if r.Time.Sub(old.start) >= h.interval {
+					delete(st.windows, k)
+				}
+			}
+		}
+		w = &sampleWindow{start: r.Time}
+		st.windows[key] = w
+	}
+	w.count++
+	if w.count > h.burst {
+		w.dropped++
+		st.mu.Unlock()
+		return nil
+	}
+	st.mu.Unlock()
+	return h.next.Handle(ctx, r)
+}
+
+func (h *samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &samplingHandler{next: h.next.WithAttrs(attrs), interval: h.interval, burst: h.burst, state: h.state}
+}
+
+func (h *samplingHandler) WithGroup(name string) slog.Handler {
+	return &samplingHandler{next: h.next.WithGroup(name), interval: h.interval, burst: h.burst, state: h.state}
+}
+
+// gormLogger 将 GORM 日志输出到 store 日志器
+type gormLogger struct {
+	level         logger.LogLevel
+	slowThreshold time.Duration
+}
+
+func (l *gormLogger) LogMode(level logger.LogLevel) logger.Interface {
+	c := *l
+	c.level = level
+	return &c
+}
+
+func (l *gormLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= logger.Info {
+		storeLog.InfoContext(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l *gormLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= logger.Warn {
+		storeLog.WarnContext(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l *gormLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= logger.Error {
+		storeLog.ErrorContext(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+// Trace 出错的 SQL 输出 error，慢查询输出 warn，其余 SQL 只在 debug 级别输出
+func (l *gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= logger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	switch {
+	case err != nil && l.level >= logger.Error && !errors.Is(err, gorm.ErrRecordNotFound):
+		sql, rows := fc()
+		storeLog.ErrorContext(ctx, "SQL 执行出错", "err", err, "elapsed", elapsed, "rows", rows, "sql", sql)
+	case l.slowThreshold > 0 && elapsed > l.slowThreshold && l.level >= logger.Warn:
+		sql, rows := fc()
+		storeLog.WarnContext(ctx, "慢查询", "elapsed", elapsed, "rows", rows, "sql", sql)
+	case l.level >= logger.Info && storeLog.Enabled(ctx, slog.LevelDebug):
+		sql, rows := fc()
+		storeLog.DebugContext(ctx, "SQL", "elapsed", elapsed, "rows", rows, "sql", sql)
+	}
+}
@@ -3,14 +3,20 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
-	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/influxdata/line-protocol/v2/lineprotocol"
+	"gorm.io/gorm/clause"
 )
 
 type TelegrafJson struct {
@@ -21,9 +27,9 @@ type TelegrafJson struct {
 	Name      string                 `json:"name"`
 }
 
-// parseJson 函数用于解析 JSON 格式的数据
-// Telegraf 发送的是数组格式: [{...}, {...}]
-func parseJson(body []byte) {
+// decodeJson 解析 JSON 格式的数据
+// Telegraf 发送的是数组格式: [{...}, {...}]，或批量格式: {"metrics": [{...}]}
+func decodeJson(body []byte) ([]TelegrafJson, error) {
 	var (
 		envelope struct {
 			Metrics []TelegrafJson `json:"metrics"`
@@ -32,80 +38,108 @@ func parseJson(body []byte) {
 	)
 
 	if err := json.Unmarshal(body, &envelope); err == nil && envelope.Metrics != nil {
-		metrics = envelope.Metrics
-	} else {
-		if err := json.Unmarshal(body, &metrics); err != nil {
-			log.Printf("解析 JSON 出错: %v", err)
-			return
-		}
+		return envelope.Metrics, nil
 	}
+	if err := json.Unmarshal(body, &metrics); err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
 
-	fmt.Println("--- 收到 JSON 格式数据 ---")
+// parseJson 函数用于解析并保存 JSON 格式的数据
+func parseJson(body []byte) {
+	metrics, err := decodeJson(body)
+	if err != nil {
+		ingestLog.Error("解析 JSON 出错", "err", err)
+		return
+	}
+	saveMetrics(metrics)
+}
+
+// saveMetrics 按测量名称保存数据，并更新主机心跳和告警状态；重复发送的数据已处理过，不再更新心跳和告警
+func saveMetrics(metrics []TelegrafJson) {
+	ingestLog.Debug("保存指标数据", "count", len(metrics))
+	now := time.Now()
 	for _, metric := range metrics {
-		fmt.Printf("Measurement: %s\n", metric.Name)
-		// 打印 Tags
-		for key, val := range metric.Tags {
-			fmt.Printf("  Tag: %s = %s\n", key, val)
-		}
+		debugPayload(&metric)
+		duplicate := false
 		switch metric.Name {
 		case "cpu":
-			SaveCPUToDB(&metric)
+			duplicate = SaveCPUToDB(&metric)
 		case "mem":
-			SaveMemInfo2DB(&metric)
+			duplicate = SaveMemInfo2DB(&metric)
 		case "disk":
-			SaveDiskInfo2DB(&metric)
+			duplicate = SaveDiskInfo2DB(&metric)
 		case "net":
-			SaveNetToDB(&metric)
+			duplicate = SaveNetToDB(&metric)
 		default:
-			log.Printf("未知的测量名称: %s", metric.Name)
+			ingestLog.Warn("未知的测量名称", "measurement", metric.Name, "host", metric.Tags["host"])
+		}
+		if duplicate {
+			continue
 		}
+		heartbeats.touch(metric.Tags["host"], metric.Name, now)
+		alerts.observe(&metric)
 	}
 }
 
-// parseLineProtocol 函数用于解析 InfluxDB Line Protocol 格式的数据
-func parseLineProtocol(body []byte) {
+// insertSample 保存一条指标数据，同一序列同一时间戳的数据已存在时忽略（Telegraf 超时重试会重复发送同一批数据），
+// 返回是否因重复而被忽略
+func insertSample(measurement string, sample interface{}) (bool, error) {
+	res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(sample)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	if res.RowsAffected == 0 {
+		dedupedMetrics.inc(measurement)
+		return true, nil
+	}
+	return false, nil
+}
+
+// decodeLineProtocol 解析 InfluxDB Line Protocol 格式的数据，时间戳保持原始数值，由 normalizeTimestamps 按 precision 换算
+// 解析出错时返回出错前已解析的数据
+func decodeLineProtocol(body []byte) ([]TelegrafJson, error) {
 	// 使用官方的 line-protocol 解析器
 	decoder := lineprotocol.NewDecoder(bytes.NewReader(body))
 
-	fmt.Println("--- 收到 InfluxDB Line Protocol 格式数据 ---")
+	var metrics []TelegrafJson
 	for decoder.Next() {
 		measurement, err := decoder.Measurement()
 		if err != nil {
-			log.Printf("解析 Measurement 出错: %v", err)
+			ingestLog.Warn("解析 Measurement 出错", "err", err)
 			continue
 		}
+		metric := TelegrafJson{
+			Name:   string(measurement),
+			Tags:   make(map[string]string),
+			Fields: make(map[string]interface{}),
+		}
 
-		fmt.Printf("Measurement: %s\n", string(measurement))
-
-		// 打印 Tags
 		for {
 			key, val, err := decoder.NextTag()
-			if err != nil {
+			if err != nil || key == nil {
 				break
 			}
-			fmt.Printf("  Tag: %s = %s\n", string(key), string(val))
+			metric.Tags[string(key)] = string(val)
 		}
 
-		// 打印 Fields
 		for {
 			key, val, err := decoder.NextField()
-			if err != nil {
+			if err != nil || key == nil {
 				break
 			}
-			fmt.Printf("  Field: %s = %v (%T)\n", string(key), val, val)
+			metric.Fields[string(key)] = val.Interface()
 		}
 
 		// 获取时间戳
 		ts, err := decoder.Time(lineprotocol.Nanosecond, time.Time{})
-		if err == nil {
-			fmt.Printf("  Timestamp: %s\n", ts)
+		if err == nil && !ts.IsZero() {
+			metric.Timestamp = ts.UnixNano()
 		}
-		fmt.Println("-------------------------------------------")
-	}
-
-	if err := decoder.Err(); err != nil {
-		log.Printf("解析 Line Protocol 出错: %v", err)
+		metrics = append(metrics, metric)
 	}
+	return metrics, decoder.Err()
 }
 
 // handleJsonMetrics 专门处理 JSON 格式的 Telegraf 数据
@@ -135,10 +169,30 @@ func handleJsonMetrics(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 4. 解析 JSON 格式
-	parseJson(body)
+	// 4. 解析 JSON 格式，时间戳统一换算为秒
+	precision, err := endpointPrecision(r, "json")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	metrics, err := decodeJson(body)
+	if err != nil {
+		parseErrors.inc("json")
+		ingestLog.Warn("解析 JSON 出错", "remote", r.RemoteAddr, "err", err)
+		http.Error(w, "无法解析 JSON 数据", http.StatusBadRequest)
+		return
+	}
+	metrics = normalizeTimestamps("json", metrics, precision, time.Now(), true)
+	metrics = filterMetrics("json", metrics)
+
+	// 5. 校验写入权限并保存
+	if !checkHostAllowed(w, r, "json", metrics) {
+		return
+	}
+	countIngested("json", metrics)
+	saveMetrics(metrics)
 
-	// 5. 返回成功响应
+	// 6. 返回成功响应
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -161,7 +215,7 @@ func handleLineProtocolMetrics(w http.ResponseWriter, r *http.Request) {
 		defer func(gzReader *gzip.Reader) {
 			err := gzReader.Close()
 			if err != nil {
-				log.Printf("关闭 Gzip Reader 出错: %v", err)
+				ingestLog.Warn("关闭 Gzip Reader 出错", "err", err)
 				return
 			}
 		}(gzReader)
@@ -175,35 +229,172 @@ func handleLineProtocolMetrics(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 4. 解析 Line Protocol 格式
-	parseLineProtocol(body)
+	// 4. 解析 Line Protocol 格式，时间戳统一换算为秒
+	precision, err := endpointPrecision(r, "lineprotocol")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	metrics, err := decodeLineProtocol(body)
+	if err != nil {
+		parseErrors.inc("lineprotocol")
+		ingestLog.Warn("解析 Line Protocol 出错", "remote", r.RemoteAddr, "err", err)
+	}
+	metrics = normalizeTimestamps("lineprotocol", metrics, precision, time.Now(), true)
+	metrics = filterMetrics("lineprotocol", metrics)
+
+	// 5. 校验写入权限
+	if !checkHostAllowed(w, r, "lineprotocol", metrics) {
+		return
+	}
+	countIngested("lineprotocol", metrics)
+	saveMetrics(metrics)
 
-	// 5. 返回成功响应
+	// 6. 返回成功响应
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// writeJSON 以 JSON 格式输出响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		apiLog.Error("输出 JSON 响应出错", "err", err)
+	}
+}
+
 func main() {
+	// 全局参数，写在子命令之前
+	var overrides overrideFlag
+	configFile := flag.String("config", envOrDefault("MOC_CONFIG", "config.json"), "配置文件路径，为空时只使用默认值、环境变量和命令行参数")
+	portFlag := flag.String("port", "", "监听端口，覆盖 server_port")
+	logLevelFlag := flag.String("log-level", "", "日志级别，覆盖 log_level")
+	flag.Var(&overrides, "set", "覆盖任意配置项，如 -set database.password=xxx，可重复")
+	flag.Usage = cliUsage
+	flag.Parse()
+	if *portFlag != "" {
+		overrides = append(overrides, "server_port="+*portFlag)
+	}
+	if *logLevelFlag != "" {
+		overrides = append(overrides, "log_level="+*logLevelFlag)
+	}
+	configOverrides = overrides
+	configPath = *configFile
+
+	if err := runCommand(flag.Args()); err != nil {
+		fatal(serverLog, "执行命令失败", "err", err)
+	}
+}
 
-	// 加载配置文件
-	if err := LoadConfig("config.json"); err != nil {
-		log.Fatalf("无法加载配置: %v", err)
+// runServe 启动采集服务，收到退出信号后优雅退出
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// 加载告警静默和维护窗口
+	if err := initSilences(); err != nil {
+		fatal(serverLog, "无法加载告警静默", "err", err)
+	}
+	// 启动告警通知
+	if err := initNotify(); err != nil {
+		fatal(serverLog, "无法加载告警通知配置", "err", err)
+	}
+	// 加载告警规则
+	if err := initAlerting(); err != nil {
+		fatal(serverLog, "无法加载告警规则", "err", err)
+	}
+	// 加载主机心跳
+	if err := initHeartbeats(); err != nil {
+		fatal(serverLog, "无法加载主机心跳", "err", err)
+	}
+	// 清理上次退出时未完成的任务记录
+	if err := initJobs(); err != nil {
+		fatal(serverLog, "无法初始化任务记录", "err", err)
 	}
-	// 加载数据库
-	InitDb()
 	// 注册数据处理任务。
-	TaskRun()
+	scheduler.Store(TaskRun())
 
 	// 注册两个不同的端点
-	http.HandleFunc("/metrics/json", handleJsonMetrics)
-	http.HandleFunc("/metrics/lineprotocol", handleLineProtocolMetrics)
+	http.HandleFunc("/metrics/json", requireIngestAuth("json", handleJsonMetrics))
+	http.HandleFunc("/metrics/lineprotocol", requireIngestAuth("lineprotocol", handleLineProtocolMetrics))
+	// 查询接口
+	http.HandleFunc("/api/quota", handleQuota)
+	http.HandleFunc("/api/report/p95", handlePercentileReport)
+	http.HandleFunc("/api/alerts", handleAlerts)
+	http.HandleFunc("/api/alerts/history", handleAlertHistory)
+	http.HandleFunc("/api/hosts", handleHosts)
+	http.HandleFunc("/api/notify/deliveries", handleNotifyDeliveries)
+	http.HandleFunc("/api/forecast/disk", handleDiskForecast)
+	http.HandleFunc("/api/anomalies", handleAnomalies)
+	http.HandleFunc("/api/memory", handleMemory)
+	http.HandleFunc("/api/cpu/heatmap", handleCPUHeatmap)
+	http.HandleFunc("/api/cpu/topology", handleCPUTopology)
+	http.HandleFunc("/api/silences", handleSilences)
+	http.HandleFunc("/api/jobs", handleJobs)
+	http.HandleFunc("/api/jobs/runs", handleJobRuns)
+	http.HandleFunc("/api/jobs/run", requireAdminAuth(handleJobRun))
+	// 自身运行指标（Prometheus 格式）
+	http.HandleFunc("/metrics", handleSelfMetrics)
+	// 健康检查和运行状态
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/readyz", handleReadyz)
+	http.HandleFunc("/status", handleStatus)
+
+	// 收到退出信号后优雅退出，后台协程随 ctx 结束
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	port := conf().ServerPort
+	srv := &http.Server{Addr: ":" + port}
+	scheme := "http"
+	if conf().TLS.Enable {
+		tlsCfg, err := buildTLSConfig(ctx, conf().TLS)
+		if err != nil {
+			fatal(serverLog, "无法加载 TLS 配置", "err", err)
+		}
+		srv.TLSConfig = tlsCfg
+		scheme = "https"
+	}
+	serverLog.Info("服务器启动，等待 Telegraf 数据", "port", port)
+	serverLog.Info("JSON 格式请配置 url", "url", fmt.Sprintf("%s://localhost:%s/metrics/json", scheme, port))
+	serverLog.Info("Line Protocol 格式请配置 url", "url", fmt.Sprintf("%s://localhost:%s/metrics/lineprotocol", scheme, port))
+
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		fatal(serverLog, "启动服务器失败", "err", err)
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		if conf().TLS.Enable {
+			// 证书由 TLSConfig.GetCertificate 提供
+			serveErr <- srv.ServeTLS(ln, "", "")
+		} else {
+			serveErr <- srv.Serve(ln)
+		}
+	}()
 
-	port := config.ServerPort
-	log.Printf("服务器启动，监听在端口 %s, 等待 Telegraf 数据...", port)
-	log.Printf("JSON 格式请配置 url 为: http://localhost:%s/metrics/json", port)
-	log.Printf("Line Protocol 格式请配置 url 为: http://localhost:%s/metrics/lineprotocol", port)
+	// 通知 systemd 服务已就绪，并启动 watchdog
+	if err := sdNotify("READY=1"); err != nil {
+		serverLog.Warn("发送 systemd 就绪通知失败", "err", err)
+	}
+	startWatchdog(ctx)
+	// 收到 SIGHUP 或配置文件修改后重新加载配置
+	go watchConfig(ctx)
+	// 多实例部署时选举执行全局任务的 leader
+	go runLeaderElection(ctx)
 
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatalf("启动服务器失败: %s\n", err)
+	select {
+	case err := <-serveErr:
+		fatal(serverLog, "启动服务器失败", "err", err)
+	case <-ctx.Done():
+		stop()
+		serverLog.Info("收到退出信号，开始优雅退出")
 	}
 
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout())
+	defer cancel()
+	shutdown(shutdownCtx, srv)
+	return nil
 }
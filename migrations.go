@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 数据迁移
+// 表和字段由 migrateDb 自动补充，需要转换已有数据的变更按版本号登记在 schemaMigrations 中，
+// 每个版本只执行一次，执行结果记录在 schema_migrations 表。
+// 版本记录和数据转换在同一事务中完成，多个实例同时启动时只有一个实例执行转换，
+// 其他实例写入版本记录时等待该实例提交，之后因主键冲突视为已执行。
+// 数据迁移只保证新版本实例之间不重复执行，升级前需先停止所有旧版本实例，否则旧版本会继续按旧格式写入数据。
+
+// SchemaMigration 已执行的数据迁移
+type SchemaMigration struct {
+	Version   int       `gorm:"primaryKey;autoIncrement:false" json:"version"` // 版本号
+	Name      string    `gorm:"type:varchar(100);not null" json:"name"`        // 迁移名称
+	AppliedAt time.Time `gorm:"not null" json:"applied_at"`                    // 执行时间
+}
+
+// TableName 指定 SchemaMigration 的表名
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// schemaMigration 一个版本的数据迁移
+type schemaMigration struct {
+	version int
+	name    string
+	up      func(tx *gorm.DB) error
+}
+
+// schemaMigrations 所有数据迁移，按版本号递增
+var schemaMigrations = []schemaMigration{
+	{1, "mem_metrics_bytes", memMetricsToBytes},
+}
+
+// runSchemaMigrations 依次执行尚未执行的数据迁移，返回本次执行的迁移名称
+func runSchemaMigrations() ([]string, error) {
+	var applied []SchemaMigration
+	if err := db.Find(&applied).Error; err != nil {
+		return nil, err
+	}
+	done := make(map[int]bool, len(applied))
+	for _, m := range applied {
+		done[m.Version] = true
+	}
+
+	var ran []string
+	for _, m := range schemaMigrations {
+		if done[m.version] {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// 先写入版本记录，其他实例同时执行时在这里因主键冲突而回滚
+			if err := tx.Create(&SchemaMigration{Version: m.version, Name: m.name, AppliedAt: time.Now()}).Error; err != nil {
+				return err
+			}
+			return m.up(tx)
+		})
+		if err != nil && isDuplicateKey(err) {
+			// 其他实例已执行该版本，重新读取确认版本记录已提交
+			var existing SchemaMigration
+			if err := db.First(&existing, m.version).Error; err != nil {
+				return ran, fmt.Errorf("读取数据迁移 %d %s 的执行记录失败: %v", m.version, m.name, err)
+			}
+			storeLog.Info("数据迁移已由其他实例执行", "version", m.version, "name", m.name, "applied_at", existing.AppliedAt)
+			continue
+		}
+		if err != nil {
+			return ran, fmt.Errorf("执行数据迁移 %d %s 失败: %v", m.version, m.name, err)
+		}
+		storeLog.Info("已执行数据迁移", "version", m.version, "name", m.name)
+		ran = append(ran, m.name)
+	}
+	return ran, nil
+}
+
+// isDuplicateKey 判断是否为唯一键冲突错误
+func isDuplicateKey(err error) bool {
+	if translator, ok := db.Dialector.(gorm.ErrorTranslator); ok {
+		err = translator.Translate(err)
+	}
+	return errors.Is(err, gorm.ErrDuplicatedKey)
+}
+
+// memMetricsToBytes 将 mem_metrics 中以 MB 保存的字段转换为字节，转换前已截断的精度无法恢复
+func memMetricsToBytes(tx *gorm.DB) error {
+	sets := make([]string, len(memByteFields))
+	for i, col := range memByteFields {
+		sets[i] = fmt.Sprintf("%s = %s * %d", col, col, 1024*1024)
+	}
+	res := tx.Exec("UPDATE mem_metrics SET " + strings.Join(sets, ", "))
+	if res.Error != nil {
+		return res.Error
+	}
+	storeLog.Info("内存数据已转换为字节", "rows", res.RowsAffected)
+	return nil
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"encoding/json"
-	"log"
 	"time"
 )
 
@@ -24,10 +23,10 @@ type NetInterfaceFields struct {
 
 // NetInterfaceFieldsDb 是用于存储网络接口统计数据的 GORM 模型
 type NetInterfaceFieldsDb struct {
-	ID        uint   `gorm:"primaryKey;autoIncrement"`         // 数据库主键
-	Host      string `gorm:"type:varchar(100);not null;index"` // 主机名
-	Interface string `gorm:"type:varchar(50);not null;index"`  // 网卡接口名
-	Timestamp int64  `gorm:"not null;index"`                   // 数据采集时间戳
+	ID        uint   `gorm:"primaryKey;autoIncrement"`                                               // 数据库主键
+	Host      string `gorm:"type:varchar(100);not null;index;uniqueIndex:idx_net_sample,priority:1"` // 主机名
+	Interface string `gorm:"type:varchar(50);not null;index;uniqueIndex:idx_net_sample,priority:2"`  // 网卡接口名
+	Timestamp int64  `gorm:"not null;index;uniqueIndex:idx_net_sample,priority:3"`                   // 数据采集时间戳（秒）
 
 	BytesRecv   int64 `gorm:"column:bytes_recv"`   // 接收的总字节数
 	BytesSent   int64 `gorm:"column:bytes_sent"`   // 发送的总字节数
@@ -50,11 +49,13 @@ func (NetInterfaceFieldsDb) TableName() string {
 
 // NetInterfaceCollectHour net Interface 按小时存储网络流量数据信息。
 type NetInterfaceCollectHour struct {
-	ID        uint      `gorm:"primaryKey;autoIncrement"`         // 数据库主键
-	Host      string    `gorm:"type:varchar(100);not null;index"` // 主机名
-	Interface string    `gorm:"type:varchar(50);not null;index"`  // 网卡接口名
-	Hour      time.Time // 小时时间戳（格式：YYYYMMDDHH）
+	ID        uint      `gorm:"primaryKey;autoIncrement"`                                             // 数据库主键
+	Host      string    `gorm:"type:varchar(100);not null;index;uniqueIndex:idx_net_hour,priority:1"` // 主机名
+	Interface string    `gorm:"type:varchar(50);not null;index;uniqueIndex:idx_net_hour,priority:2"`  // 网卡接口名
+	Hour      time.Time `gorm:"uniqueIndex:idx_net_hour,priority:3"`                                  // 小时时间戳（格式：YYYYMMDDHH）
 	Total     int64     // 小时内总流量（MB）
+	RecvBytes int64     `gorm:"not null;default:0"` // 小时内接收流量（字节）
+	SentBytes int64     `gorm:"not null;default:0"` // 小时内发送流量（字节）
 	Speed     float64   `gorm:"type:decimal(10,2)"` // 小时内平均速度（Mbps）保留两位小数
 	SpeedStr  string    `gorm:"type:varchar(20)"`   // 格式化后的平均速度（e.g., "1.5 Mbps", "500 Kbps"）
 	CreatedAt time.Time // 记录创建时间
@@ -65,6 +66,24 @@ func (NetInterfaceCollectHour) TableName() string {
 	return "net_interface_collect_hours"
 }
 
+// NetInterfaceCollect5Min net Interface 按 5 分钟存储网络流量数据，用于 95 计费统计。
+type NetInterfaceCollect5Min struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement"`                                             // 数据库主键
+	Host      string    `gorm:"type:varchar(100);not null;index;uniqueIndex:idx_net_5min,priority:1"` // 主机名
+	Interface string    `gorm:"type:varchar(50);not null;index;uniqueIndex:idx_net_5min,priority:2"`  // 网卡接口名
+	Bucket    time.Time `gorm:"not null;index;uniqueIndex:idx_net_5min,priority:3"`                   // 5 分钟时间段起点
+	RecvBytes int64     `gorm:"not null"`                                                             // 时间段内接收流量（字节）
+	SentBytes int64     `gorm:"not null"`                                                             // 时间段内发送流量（字节）
+	RecvBps   float64   `gorm:"type:decimal(20,2);not null"`                                          // 时间段内平均接收速率（bits/s）
+	SentBps   float64   `gorm:"type:decimal(20,2);not null"`                                          // 时间段内平均发送速率（bits/s）
+	CreatedAt time.Time // 记录创建时间
+}
+
+// TableName 指定 NetInterfaceCollect5Min 的表名
+func (NetInterfaceCollect5Min) TableName() string {
+	return "net_interface_collect_5min"
+}
+
 // FromNetInterfaceFields 从 NetInterfaceFields 和标签填充 NetInterfaceFieldsDb
 func (db *NetInterfaceFieldsDb) FromNetInterfaceFields(host, iface string, timestamp int64, fields NetInterfaceFields) {
 	db.Host = host
@@ -90,16 +109,17 @@ func (n *NetInterfaceFields) FromFieldsMap(m map[string]interface{}) error {
 	return json.Unmarshal(b, n)
 }
 
-// SaveNetToDB 根据 tags 区分并保存网络数据到相应的数据库表
-func SaveNetToDB(metric *TelegrafJson) {
+// SaveNetToDB 根据 tags 区分并保存网络数据到相应的数据库表，返回是否为已保存过的重复数据
+func SaveNetToDB(metric *TelegrafJson) bool {
 	iface, isInterfaceMetric := metric.Tags["interface"]
 
 	if isInterfaceMetric && iface != "all" {
 		// 处理单个网卡的数据
 		var netFields NetInterfaceFields
 		if err := netFields.FromFieldsMap(metric.Fields); err != nil {
-			log.Printf("解析网络接口字段出错: %v", err)
-			return
+			fieldErrors.inc("net")
+			storeLog.Warn("解析网络接口字段出错", "host", metric.Tags["host"], "interface", iface, "err", err)
+			return false
 		}
 		var netDb NetInterfaceFieldsDb
 		netDb.FromNetInterfaceFields(
@@ -109,11 +129,14 @@ func SaveNetToDB(metric *TelegrafJson) {
 			netFields,
 		)
 		// 此处应调用 gorm.DB.Create(&netDb) 来保存数据
-		log.Printf("准备保存网络接口数据: %+v", netDb)
-		if err := db.Create(&netDb).Error; err != nil {
-			log.Printf("保存内存数据到数据库出错: %v", err)
-			return
+		if conf().Log.DebugPayloads {
+			storeLog.Debug("准备保存网络接口数据", "data", netDb)
 		}
-
+		duplicate, err := insertSample("net", &netDb)
+		if err != nil {
+			storeLog.Error("保存网络接口数据到数据库出错", "err", err)
+		}
+		return duplicate
 	}
+	return false
 }
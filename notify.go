@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// 告警通知
+// 告警触发和恢复时进入通知队列，同一规则、同一状态的告警在 group_wait 内合并为一条消息，
+// 再发送到所有配置的通知渠道。每个渠道独立重试（指数退避）和限流，发送结果记录在 notification_deliveries 表。
+
+// notifyConfig 告警通知配置
+type notifyConfig struct {
+	GroupWait string                `json:"group_wait"` // 合并等待时间，默认 30s
+	Channels  []notifyChannelConfig `json:"channels"`   // 通知渠道
+}
+
+// notifyChannelConfig 单个通知渠道配置
+type notifyChannelConfig struct {
+	Name         string            `json:"name"`          // 渠道名称
+	Type         string            `json:"type"`          // webhook、smtp、dingtalk、wecom、feishu、slack
+	URL          string            `json:"url"`           // webhook 地址
+	Headers      map[string]string `json:"headers"`       // 额外的 HTTP 请求头（仅 webhook）
+	Template     string            `json:"template"`      // 消息模板 (text/template)，webhook 为请求体，其余为消息正文
+	Secret       string            `json:"secret"`        // 钉钉、飞书的签名密钥
+	SMTP         smtpConfig        `json:"smtp"`          // 邮件配置（仅 smtp）
+	Timeout      string            `json:"timeout"`       // 单次请求超时，默认 10s
+	MaxRetries   int               `json:"max_retries"`   // 失败重试次数，默认 3
+	RetryBackoff string            `json:"retry_backoff"` // 首次重试等待时间，之后每次翻倍，默认 2s
+	RateLimit    int               `json:"rate_limit"`    // 每分钟最多发送的消息数，0 表示不限制
+}
+
+// smtpConfig 邮件通知配置
+type smtpConfig struct {
+	Host     string   `json:"host"`
+	Port     string   `json:"port"` // 465 使用 TLS 直连，其余端口在服务器支持时使用 STARTTLS
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+// NotificationDelivery 通知发送记录
+type NotificationDelivery struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`              // 数据库主键
+	Channel   string    `gorm:"type:varchar(100);not null;index" json:"channel"` // 渠道名称
+	Type      string    `gorm:"type:varchar(20);not null" json:"type"`           // 渠道类型
+	GroupKey  string    `gorm:"type:varchar(255);not null" json:"group_key"`     // 分组键（规则 + 状态）
+	AlertIDs  string    `gorm:"type:text" json:"alert_ids"`                      // 本次发送包含的告警 ID，逗号分隔
+	Status    string    `gorm:"type:varchar(20);not null;index" json:"status"`   // sent、failed、rate_limited
+	Attempts  int       `gorm:"not null" json:"attempts"`                        // 尝试次数
+	Error     string    `gorm:"type:text" json:"error"`                          // 最后一次错误
+	CreatedAt time.Time `gorm:"index" json:"created_at"`                         // 记录时间
+}
+
+// TableName 指定 NotificationDelivery 的表名
+func (NotificationDelivery) TableName() string {
+	return "notification_deliveries"
+}
+
+// notifyMessage 一次通知的内容，也是消息模板的数据
+type notifyMessage struct {
+	GroupKey string   `json:"group_key"`
+	Rule     string   `json:"rule"`
+	Status   string   `json:"status"` // firing 或 resolved
+	Title    string   `json:"title"`
+	Text     string   `json:"text"`
+	Alerts   []*Alert `json:"alerts"`
+}
+
+// notifier 通知渠道
+type notifier interface {
+	send(ctx context.Context, msg *notifyMessage) error
+}
+
+// notifyChannel 带重试和限流的通知渠道
+type notifyChannel struct {
+	cfg      notifyChannelConfig
+	notifier notifier
+	timeout  time.Duration
+	backoff  time.Duration
+
+	mu   sync.Mutex
+	sent []time.Time // 最近一分钟的发送时间，用于限流
+}
+
+// allow 判断当前是否还能发送
+func (c *notifyChannel) allow(now time.Time) bool {
+	if c.cfg.RateLimit <= 0 {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cutoff := now.Add(-time.Minute)
+	kept := c.sent[:0]
+	for _, t := range c.sent {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	c.sent = kept
+	if len(c.sent) >= c.cfg.RateLimit {
+		return false
+	}
+	c.sent = append(c.sent, now)
+	return true
+}
+
+// deliver 发送一条消息，失败时按指数退避重试，并记录发送结果；ctx 结束后不再重试
+func (c *notifyChannel) deliver(ctx context.Context, msg *notifyMessage) {
+	record := NotificationDelivery{
+		Channel:  c.cfg.Name,
+		Type:     c.cfg.Type,
+		GroupKey: msg.GroupKey,
+		AlertIDs: alertIDs(msg.Alerts),
+	}
+
+	if !c.allow(time.Now()) {
+		record.Status = "rate_limited"
+		saveDelivery(&record)
+		notifyLog.Warn("通知渠道超过限流，丢弃消息", "channel", c.cfg.Name, "title", msg.Title)
+		return
+	}
+
+	maxRetries := c.cfg.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = 3
+	}
+	backoff := c.backoff
+	var err error
+retry:
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				err = fmt.Errorf("%v（服务停止，不再重试）", err)
+				break retry
+			}
+			backoff *= 2
+		}
+		record.Attempts = attempt + 1
+		sendCtx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		err = c.notifier.send(sendCtx, msg)
+		cancel()
+		if err == nil {
+			break
+		}
+		notifyLog.Warn("通知渠道发送失败", "channel", c.cfg.Name, "attempt", attempt+1, "err", err)
+	}
+
+	if err != nil {
+		record.Status = "failed"
+		record.Error = err.Error()
+	} else {
+		record.Status = "sent"
+	}
+	saveDelivery(&record)
+}
+
+// saveDelivery 写入通知发送记录
+func saveDelivery(record *NotificationDelivery) {
+	if err := db.Create(record).Error; err != nil {
+		notifyLog.Error("保存通知记录失败", "channel", record.Channel, "err", err)
+	}
+}
+
+// alertIDs 将告警 ID 拼接为逗号分隔的字符串
+func alertIDs(list []*Alert) string {
+	ids := make([]string, len(list))
+	for i, a := range list {
+		ids[i] = fmt.Sprint(a.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+// alertGroup 等待合并发送的告警
+type alertGroup struct {
+	key     string
+	rule    string
+	status  string
+	alerts  []*Alert
+	created time.Time
+}
+
+// notifyDispatcher 合并告警并分发到各个渠道
+type notifyDispatcher struct {
+	queue     chan *Alert
+	groupWait time.Duration
+	channels  []*notifyChannel
+	groups    map[string]*alertGroup
+	wg        sync.WaitGroup
+
+	// 停止后不再接收新告警，done 在分发协程退出后关闭
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+
+	// 停止时取消，正在等待重试的发送不再重试
+	retryCtx    context.Context
+	cancelRetry context.CancelFunc
+}
+
+// dispatcher 当前的通知分发器，未配置通知渠道时为 nil
+var dispatcher atomic.Pointer[notifyDispatcher]
+
+// initNotify 根据配置创建通知渠道并启动分发协程
+// 重新加载配置时替换分发器，旧分发器中已合并的告警在后台发送完
+func initNotify() error {
+	d, err := newNotifyDispatcher(conf().Notify)
+	if err != nil {
+		return err
+	}
+	if d != nil {
+		go d.run()
+		notifyLog.Info("告警通知已启用", "channels", len(d.channels))
+	}
+	if old := dispatcher.Swap(d); old != nil {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			if err := old.stop(ctx); err != nil {
+				notifyLog.Warn("等待旧通知分发器发送完成超时", "err", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// newNotifyDispatcher 根据配置创建分发器，没有通知渠道时返回 nil
+func newNotifyDispatcher(cfg notifyConfig) (*notifyDispatcher, error) {
+	groupWait := 30 * time.Second
+	if cfg.GroupWait != "" {
+		d, err := time.ParseDuration(cfg.GroupWait)
+		if err != nil {
+			return nil, fmt.Errorf("无法解析 group_wait: %v", err)
+		}
+		groupWait = d
+	}
+
+	var channels []*notifyChannel
+	for _, chCfg := range cfg.Channels {
+		ch, err := newNotifyChannel(chCfg)
+		if err != nil {
+			return nil, fmt.Errorf("通知渠道 %q 无效: %v", chCfg.Name, err)
+		}
+		channels = append(channels, ch)
+	}
+	if len(channels) == 0 {
+		return nil, nil
+	}
+
+	retryCtx, cancelRetry := context.WithCancel(context.Background())
+	return &notifyDispatcher{
+		queue:       make(chan *Alert, 1000),
+		groupWait:   groupWait,
+		channels:    channels,
+		groups:      make(map[string]*alertGroup),
+		done:        make(chan struct{}),
+		retryCtx:    retryCtx,
+		cancelRetry: cancelRetry,
+	}, nil
+}
+
+// newNotifyChannel 根据渠道类型创建通知渠道
+func newNotifyChannel(cfg notifyChannelConfig) (*notifyChannel, error) {
+	if cfg.Name == "" {
+		cfg.Name = cfg.Type
+	}
+	ch := &notifyChannel{cfg: cfg, timeout: 10 * time.Second, backoff: 2 * time.Second}
+	if cfg.Timeout != "" {
+		d, err := time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("无法解析 timeout: %v", err)
+		}
+		ch.timeout = d
+	}
+	if cfg.RetryBackoff != "" {
+		d, err := time.ParseDuration(cfg.RetryBackoff)
+		if err != nil {
+			return nil, fmt.Errorf("无法解析 retry_backoff: %v", err)
+		}
+		ch.backoff = d
+	}
+
+	var tmpl *template.Template
+	if cfg.Template != "" {
+		t, err := template.New(cfg.Name).Parse(cfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("无法解析消息模板: %v", err)
+		}
+		tmpl = t
+	}
+
+	client := &http.Client{}
+	switch cfg.Type {
+	case "webhook":
+		ch.notifier = &webhookNotifier{url: cfg.URL, headers: cfg.Headers, tmpl: tmpl, client: client}
+	case "dingtalk", "wecom", "feishu", "slack":
+		ch.notifier = &chatNotifier{kind: cfg.Type, url: cfg.URL, secret: cfg.Secret, tmpl: tmpl, client: client}
+	case "smtp":
+		if cfg.SMTP.Host == "" || len(cfg.SMTP.To) == 0 {
+			return nil, fmt.Errorf("smtp 渠道需要配置 host 和 to")
+		}
+		ch.notifier = &smtpNotifier{cfg: cfg.SMTP, tmpl: tmpl}
+	default:
+		return nil, fmt.Errorf("不支持的渠道类型: %q", cfg.Type)
+	}
+	if cfg.Type != "smtp" && cfg.URL == "" {
+		return nil, fmt.Errorf("缺少 url")
+	}
+	return ch, nil
+}
+
+// enqueueNotify 将告警放入通知队列，队列满时丢弃
+func enqueueNotify(a *Alert) {
+	d := dispatcher.Load()
+	if d == nil {
+		return
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		notifyLog.Warn("通知已停止，丢弃告警通知", "rule", a.Rule)
+		return
+	}
+	snapshot := *a
+	select {
+	case d.queue <- &snapshot:
+	default:
+		notifyLog.Warn("通知队列已满，丢弃告警通知", "rule", a.Rule)
+	}
+}
+
+// run 分发协程，按规则和状态合并告警，超过 group_wait 后发送
+func (d *notifyDispatcher) run() {
+	defer close(d.done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case a, ok := <-d.queue:
+			if !ok {
+				d.flush(time.Time{})
+				return
+			}
+			key := a.Rule + "/" + a.State
+			g, exists := d.groups[key]
+			if !exists {
+				g = &alertGroup{key: key, rule: a.Rule, status: a.State, created: time.Now()}
+				d.groups[key] = g
+			}
+			g.alerts = append(g.alerts, a)
+		case now := <-ticker.C:
+			d.flush(now.Add(-d.groupWait))
+		}
+	}
+}
+
+// flush 发送创建时间早于 before 的分组，before 为零值时发送全部分组
+func (d *notifyDispatcher) flush(before time.Time) {
+	for key, g := range d.groups {
+		if !before.IsZero() && g.created.After(before) {
+			continue
+		}
+		delete(d.groups, key)
+		msg := buildNotifyMessage(g)
+		for _, ch := range d.channels {
+			d.wg.Add(1)
+			go func(ch *notifyChannel) {
+				defer d.wg.Done()
+				ch.deliver(d.retryCtx, msg)
+			}(ch)
+		}
+	}
+}
+
+// stop 停止接收新告警，立即发送所有未到 group_wait 的分组，并等待发送完成或 ctx 超时；
+// 失败的发送不再按退避时间重试，避免退出时等待整个重试周期
+func (d *notifyDispatcher) stop(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	d.mu.Unlock()
+	d.cancelRetry()
+
+	sent := make(chan struct{})
+	go func() {
+		<-d.done
+		d.wg.Wait()
+		close(sent)
+	}()
+	select {
+	case <-sent:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// buildNotifyMessage 生成默认的消息标题和正文
+func buildNotifyMessage(g *alertGroup) *notifyMessage {
+	sort.Slice(g.alerts, func(i, j int) bool { return g.alerts[i].Host < g.alerts[j].Host })
+
+	title := fmt.Sprintf("[%s] %s (%d)", strings.ToUpper(g.status), g.rule, len(g.alerts))
+	var b strings.Builder
+	b.WriteString(title + "\n")
+	for _, a := range g.alerts {
+		fmt.Fprintf(&b, "- [%s] host=%s: %s\n", a.Severity, a.Host, a.Summary)
+	}
+	return &notifyMessage{
+		GroupKey: g.key,
+		Rule:     g.rule,
+		Status:   g.status,
+		Title:    title,
+		Text:     b.String(),
+		Alerts:   g.alerts,
+	}
+}
+
+// renderTemplate 使用自定义模板渲染消息，未配置模板时返回默认正文
+func renderTemplate(tmpl *template.Template, msg *notifyMessage) (string, error) {
+	if tmpl == nil {
+		return msg.Text, nil
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, msg); err != nil {
+		return "", fmt.Errorf("渲染消息模板失败: %v", err)
+	}
+	return buf.String(), nil
+}
+
+// handleNotifyDeliveries 查询通知发送记录，参数: channel、status、limit
+func handleNotifyDeliveries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "只接受 GET 请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	tx := db.Order("id DESC").Limit(queryLimit(r))
+	if channel := query.Get("channel"); channel != "" {
+		tx = tx.Where("channel = ?", channel)
+	}
+	if status := query.Get("status"); status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+
+	var result []NotificationDelivery
+	if err := tx.Find(&result).Error; err != nil {
+		http.Error(w, "查询通知记录失败", http.StatusInternalServerError)
+		apiLog.Error("查询通知记录失败", "err", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// webhookNotifier 通用 JSON webhook
+// 未配置模板时发送 notifyMessage 的 JSON，配置模板时发送模板渲染结果
+type webhookNotifier struct {
+	url     string
+	headers map[string]string
+	tmpl    *template.Template
+	client  *http.Client
+}
+
+func (n *webhookNotifier) send(ctx context.Context, msg *notifyMessage) error {
+	var body []byte
+	if n.tmpl != nil {
+		text, err := renderTemplate(n.tmpl, msg)
+		if err != nil {
+			return err
+		}
+		body = []byte(text)
+	} else {
+		b, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		body = b
+	}
+	_, err := postJSON(ctx, n.client, n.url, body, n.headers)
+	return err
+}
+
+// chatNotifier 钉钉、企业微信、飞书、Slack 的群机器人 webhook
+type chatNotifier struct {
+	kind   string
+	url    string
+	secret string
+	tmpl   *template.Template
+	client *http.Client
+}
+
+func (n *chatNotifier) send(ctx context.Context, msg *notifyMessage) error {
+	text, err := renderTemplate(n.tmpl, msg)
+	if err != nil {
+		return err
+	}
+
+	target := n.url
+	var payload interface{}
+	switch n.kind {
+	case "dingtalk":
+		payload = map[string]interface{}{
+			"msgtype":  "markdown",
+			"markdown": map[string]string{"title": msg.Title, "text": text},
+		}
+		if n.secret != "" {
+			timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+			sign := hmacBase64(n.secret, timestamp+"\n"+n.secret)
+			u, err := url.Parse(n.url)
+			if err != nil {
+				return err
+			}
+			q := u.Query()
+			q.Set("timestamp", timestamp)
+			q.Set("sign", sign)
+			u.RawQuery = q.Encode()
+			target = u.String()
+		}
+	case "wecom":
+		payload = map[string]interface{}{
+			"msgtype":  "markdown",
+			"markdown": map[string]string{"content": text},
+		}
+	case "feishu":
+		body := map[string]interface{}{
+			"msg_type": "text",
+			"content":  map[string]string{"text": text},
+		}
+		if n.secret != "" {
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			// 飞书签名以 timestamp + "\n" + secret 为密钥，对空字符串计算 HMAC
+			body["timestamp"] = timestamp
+			body["sign"] = hmacBase64(timestamp+"\n"+n.secret, "")
+		}
+		payload = body
+	default:
+		payload = map[string]string{"text": text}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := postJSON(ctx, n.client, target, body, nil)
+	if err != nil {
+		return err
+	}
+	return checkChatResponse(resp)
+}
+
+// hmacBase64 计算 HMAC-SHA256 并进行 base64 编码
+func hmacBase64(key, data string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(data))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// checkChatResponse 群机器人接口在 HTTP 200 时也可能通过 errcode/code 返回错误
+func checkChatResponse(body []byte) error {
+	var result struct {
+		ErrCode *int   `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+		Code    *int   `json:"code"`
+		Msg     string `json:"msg"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		// Slack 返回纯文本 "ok"
+		return nil
+	}
+	if result.ErrCode != nil && *result.ErrCode != 0 {
+		return fmt.Errorf("errcode=%d: %s", *result.ErrCode, result.ErrMsg)
+	}
+	if result.Code != nil && *result.Code != 0 {
+		return fmt.Errorf("code=%d: %s", *result.Code, result.Msg)
+	}
+	return nil
+}
+
+// postJSON 发送 JSON 请求，非 2xx 状态码视为失败，返回响应体
+func postJSON(ctx context.Context, client *http.Client, target string, body []byte, headers map[string]string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return respBody, nil
+}
+
+// smtpNotifier 邮件通知
+type smtpNotifier struct {
+	cfg  smtpConfig
+	tmpl *template.Template
+}
+
+func (n *smtpNotifier) send(ctx context.Context, msg *notifyMessage) error {
+	text, err := renderTemplate(n.tmpl, msg)
+	if err != nil {
+		return err
+	}
+
+	port := n.cfg.Port
+	if port == "" {
+		port = "25"
+	}
+	addr := net.JoinHostPort(n.cfg.Host, port)
+	from := n.cfg.From
+	if from == "" {
+		from = n.cfg.Username
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(n.cfg.To, ", "))
+	fmt.Fprintf(&b, "Subject: =?UTF-8?B?%s?=\r\n", base64.StdEncoding.EncodeToString([]byte(msg.Title)))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+	b.WriteString(base64.StdEncoding.EncodeToString([]byte(text)))
+	b.WriteString("\r\n")
+
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	if port == "465" {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: n.cfg.Host}}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, n.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if port != "465" {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(&tls.Config{ServerName: n.cfg.Host}); err != nil {
+				return err
+			}
+		}
+	}
+	if n.cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", n.cfg.Username, n.cfg.Password, n.cfg.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, to := range n.cfg.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
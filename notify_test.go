@@ -0,0 +1,519 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// recordDeliveries 将 db 替换为不连接数据库的 DryRun 实例，返回写入的通知发送记录
+func recordDeliveries(t *testing.T) func() []NotificationDelivery {
+	t.Helper()
+	testDB, err := gorm.Open(mysql.New(mysql.Config{DSN: "test@tcp(127.0.0.1:1)/test", SkipInitializeWithVersion: true}),
+		&gorm.Config{DryRun: true, DisableAutomaticPing: true, Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var records []NotificationDelivery
+	err = testDB.Callback().Create().After("gorm:create").Register("test:record", func(tx *gorm.DB) {
+		if d, ok := tx.Statement.Dest.(*NotificationDelivery); ok {
+			mu.Lock()
+			records = append(records, *d)
+			mu.Unlock()
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := db
+	db = testDB
+	t.Cleanup(func() { db = old })
+	return func() []NotificationDelivery {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]NotificationDelivery(nil), records...)
+	}
+}
+
+// testMessage 返回包含两条告警的测试消息
+func testMessage() *notifyMessage {
+	return buildNotifyMessage(&alertGroup{
+		key:    "cpu_high/firing",
+		rule:   "cpu_high",
+		status: "firing",
+		alerts: []*Alert{
+			{ID: 2, Rule: "cpu_high", Host: "web-2", Severity: "critical", Summary: "cpu 97"},
+			{ID: 1, Rule: "cpu_high", Host: "web-1", Severity: "warning", Summary: "cpu 91"},
+		},
+	})
+}
+
+// capturedRequest 测试服务器收到的请求
+type capturedRequest struct {
+	at     time.Time
+	query  url.Values
+	header http.Header
+	body   []byte
+}
+
+// captureServer 启动记录请求的 httptest 服务器，status 返回第 n 次请求（从 0 开始）的状态码和响应体
+func captureServer(t *testing.T, status func(n int) (int, string)) (*httptest.Server, func() []capturedRequest) {
+	t.Helper()
+	var mu sync.Mutex
+	var reqs []capturedRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		n := len(reqs)
+		reqs = append(reqs, capturedRequest{at: time.Now(), query: r.URL.Query(), header: r.Header.Clone(), body: body})
+		mu.Unlock()
+		code, resp := http.StatusOK, `{"errcode":0}`
+		if status != nil {
+			code, resp = status(n)
+		}
+		w.WriteHeader(code)
+		io.WriteString(w, resp)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []capturedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]capturedRequest(nil), reqs...)
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	deliveries := recordDeliveries(t)
+	srv, requests := captureServer(t, nil)
+
+	ch, err := newNotifyChannel(notifyChannelConfig{
+		Name:     "ops",
+		Type:     "webhook",
+		URL:      srv.URL,
+		Headers:  map[string]string{"X-Token": "abc"},
+		Template: `{"rule":"{{.Rule}}","status":"{{.Status}}","hosts":[{{range $i, $a := .Alerts}}{{if $i}},{{end}}"{{$a.Host}}"{{end}}]}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch.deliver(context.Background(), testMessage())
+
+	reqs := requests()
+	if len(reqs) != 1 {
+		t.Fatalf("收到 %d 次请求，期望 1 次", len(reqs))
+	}
+	want := `{"rule":"cpu_high","status":"firing","hosts":["web-1","web-2"]}`
+	if got := string(reqs[0].body); got != want {
+		t.Errorf("请求体 = %s，期望 %s", got, want)
+	}
+	if got := reqs[0].header.Get("X-Token"); got != "abc" {
+		t.Errorf("X-Token = %q", got)
+	}
+	if got := reqs[0].header.Get("Content-Type"); !strings.HasPrefix(got, "application/json") {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	records := deliveries()
+	if len(records) != 1 {
+		t.Fatalf("发送记录 %d 条，期望 1 条", len(records))
+	}
+	r := records[0]
+	if r.Channel != "ops" || r.Type != "webhook" || r.Status != "sent" || r.Attempts != 1 ||
+		r.GroupKey != "cpu_high/firing" || r.AlertIDs != "1,2" {
+		t.Errorf("发送记录不符: %+v", r)
+	}
+}
+
+func TestWebhookDefaultBody(t *testing.T) {
+	recordDeliveries(t)
+	srv, requests := captureServer(t, nil)
+
+	ch, err := newNotifyChannel(notifyChannelConfig{Type: "webhook", URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch.deliver(context.Background(), testMessage())
+
+	reqs := requests()
+	if len(reqs) != 1 {
+		t.Fatalf("收到 %d 次请求，期望 1 次", len(reqs))
+	}
+	var msg notifyMessage
+	if err := json.Unmarshal(reqs[0].body, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Title != "[FIRING] cpu_high (2)" || len(msg.Alerts) != 2 || msg.Alerts[0].Host != "web-1" {
+		t.Errorf("默认请求体不符: %s", reqs[0].body)
+	}
+}
+
+func TestWebhookRetryBackoff(t *testing.T) {
+	deliveries := recordDeliveries(t)
+	srv, requests := captureServer(t, func(n int) (int, string) {
+		if n < 2 {
+			return http.StatusBadGateway, "busy"
+		}
+		return http.StatusOK, ""
+	})
+
+	ch, err := newNotifyChannel(notifyChannelConfig{Type: "webhook", URL: srv.URL, RetryBackoff: "20ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch.deliver(context.Background(), testMessage())
+
+	reqs := requests()
+	if len(reqs) != 3 {
+		t.Fatalf("收到 %d 次请求，期望 3 次", len(reqs))
+	}
+	// 第一次重试等待 20ms，之后翻倍
+	if d := reqs[1].at.Sub(reqs[0].at); d < 20*time.Millisecond {
+		t.Errorf("第一次重试间隔 %v，期望至少 20ms", d)
+	}
+	if d := reqs[2].at.Sub(reqs[1].at); d < 40*time.Millisecond {
+		t.Errorf("第二次重试间隔 %v，期望至少 40ms", d)
+	}
+
+	records := deliveries()
+	if len(records) != 1 || records[0].Status != "sent" || records[0].Attempts != 3 || records[0].Error != "" {
+		t.Errorf("发送记录不符: %+v", records)
+	}
+}
+
+func TestWebhookRetryExhausted(t *testing.T) {
+	deliveries := recordDeliveries(t)
+	srv, requests := captureServer(t, func(int) (int, string) {
+		return http.StatusInternalServerError, "boom"
+	})
+
+	ch, err := newNotifyChannel(notifyChannelConfig{Type: "webhook", URL: srv.URL, MaxRetries: 2, RetryBackoff: "1ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch.deliver(context.Background(), testMessage())
+
+	if n := len(requests()); n != 3 {
+		t.Errorf("收到 %d 次请求，期望 3 次", n)
+	}
+	records := deliveries()
+	if len(records) != 1 {
+		t.Fatalf("发送记录 %d 条，期望 1 条", len(records))
+	}
+	r := records[0]
+	if r.Status != "failed" || r.Attempts != 3 || !strings.Contains(r.Error, "HTTP 500: boom") {
+		t.Errorf("发送记录不符: %+v", r)
+	}
+}
+
+func TestWebhookRetryCanceled(t *testing.T) {
+	deliveries := recordDeliveries(t)
+	srv, requests := captureServer(t, func(int) (int, string) {
+		return http.StatusInternalServerError, "boom"
+	})
+
+	ch, err := newNotifyChannel(notifyChannelConfig{Type: "webhook", URL: srv.URL, RetryBackoff: "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 服务停止时不再等待退避时间
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	ch.deliver(ctx, testMessage())
+
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("取消后等待了 %v", d)
+	}
+	if n := len(requests()); n != 1 {
+		t.Errorf("收到 %d 次请求，期望 1 次", n)
+	}
+	records := deliveries()
+	if len(records) != 1 || records[0].Status != "failed" || records[0].Attempts != 1 || !strings.Contains(records[0].Error, "不再重试") {
+		t.Errorf("发送记录不符: %+v", records)
+	}
+}
+
+func TestNotifyRateLimit(t *testing.T) {
+	deliveries := recordDeliveries(t)
+	srv, requests := captureServer(t, nil)
+
+	ch, err := newNotifyChannel(notifyChannelConfig{Type: "webhook", URL: srv.URL, RateLimit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		ch.deliver(context.Background(), testMessage())
+	}
+
+	if n := len(requests()); n != 2 {
+		t.Errorf("收到 %d 次请求，期望 2 次", n)
+	}
+	var statuses []string
+	for _, r := range deliveries() {
+		statuses = append(statuses, r.Status)
+	}
+	if got := strings.Join(statuses, ","); got != "sent,sent,rate_limited" {
+		t.Errorf("发送状态 = %s", got)
+	}
+
+	// 一分钟之前的发送不再计入限流
+	now := time.Now()
+	if ch.allow(now) {
+		t.Error("限流窗口内不应允许发送")
+	}
+	if !ch.allow(now.Add(time.Minute + time.Second)) {
+		t.Error("限流窗口过后应允许发送")
+	}
+}
+
+func TestChatChannels(t *testing.T) {
+	tests := []struct {
+		kind   string
+		secret string
+		check  func(t *testing.T, req capturedRequest, payload map[string]interface{})
+	}{
+		{"dingtalk", "SEC123", func(t *testing.T, req capturedRequest, payload map[string]interface{}) {
+			md, _ := payload["markdown"].(map[string]interface{})
+			if payload["msgtype"] != "markdown" || md["title"] != "[FIRING] cpu_high (2)" || md["text"] != "cpu_high: 2" {
+				t.Errorf("钉钉消息不符: %v", payload)
+			}
+			timestamp := req.query.Get("timestamp")
+			if want := hmacBase64("SEC123", timestamp+"\nSEC123"); timestamp == "" || req.query.Get("sign") != want {
+				t.Errorf("钉钉签名不符: %v", req.query)
+			}
+		}},
+		{"wecom", "", func(t *testing.T, _ capturedRequest, payload map[string]interface{}) {
+			md, _ := payload["markdown"].(map[string]interface{})
+			if payload["msgtype"] != "markdown" || md["content"] != "cpu_high: 2" {
+				t.Errorf("企业微信消息不符: %v", payload)
+			}
+		}},
+		{"feishu", "SEC456", func(t *testing.T, _ capturedRequest, payload map[string]interface{}) {
+			content, _ := payload["content"].(map[string]interface{})
+			if payload["msg_type"] != "text" || content["text"] != "cpu_high: 2" {
+				t.Errorf("飞书消息不符: %v", payload)
+			}
+			timestamp, _ := payload["timestamp"].(string)
+			if want := hmacBase64(timestamp+"\nSEC456", ""); timestamp == "" || payload["sign"] != want {
+				t.Errorf("飞书签名不符: %v", payload)
+			}
+		}},
+		{"slack", "", func(t *testing.T, _ capturedRequest, payload map[string]interface{}) {
+			if payload["text"] != "cpu_high: 2" {
+				t.Errorf("Slack 消息不符: %v", payload)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			deliveries := recordDeliveries(t)
+			srv, requests := captureServer(t, nil)
+			ch, err := newNotifyChannel(notifyChannelConfig{
+				Type:     tt.kind,
+				URL:      srv.URL + "/robot/send?access_token=x",
+				Secret:   tt.secret,
+				Template: "{{.Rule}}: {{len .Alerts}}",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ch.deliver(context.Background(), testMessage())
+
+			reqs := requests()
+			if len(reqs) != 1 {
+				t.Fatalf("收到 %d 次请求，期望 1 次", len(reqs))
+			}
+			if reqs[0].query.Get("access_token") != "x" {
+				t.Errorf("丢失了原有的查询参数: %v", reqs[0].query)
+			}
+			var payload map[string]interface{}
+			if err := json.Unmarshal(reqs[0].body, &payload); err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, reqs[0], payload)
+			if records := deliveries(); len(records) != 1 || records[0].Status != "sent" || records[0].Type != tt.kind {
+				t.Errorf("发送记录不符: %+v", records)
+			}
+		})
+	}
+}
+
+func TestChatErrorResponse(t *testing.T) {
+	deliveries := recordDeliveries(t)
+	// 群机器人在 HTTP 200 时通过 errcode 返回错误，也应重试并记录失败
+	srv, requests := captureServer(t, func(int) (int, string) {
+		return http.StatusOK, `{"errcode":310000,"errmsg":"sign not match"}`
+	})
+
+	ch, err := newNotifyChannel(notifyChannelConfig{Type: "dingtalk", URL: srv.URL, MaxRetries: 1, RetryBackoff: "1ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch.deliver(context.Background(), testMessage())
+
+	if n := len(requests()); n != 2 {
+		t.Errorf("收到 %d 次请求，期望 2 次", n)
+	}
+	records := deliveries()
+	if len(records) != 1 || records[0].Status != "failed" || !strings.Contains(records[0].Error, "errcode=310000") {
+		t.Errorf("发送记录不符: %+v", records)
+	}
+}
+
+// smtpSession 测试 SMTP 服务器收到的一次邮件
+type smtpSession struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTPServer 在 net.Listener 上实现最小的 SMTP 服务器（支持 AUTH PLAIN，不支持 STARTTLS），只处理一个连接
+func fakeSMTPServer(t *testing.T) (host, port string, result <-chan smtpSession) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	ch := make(chan smtpSession, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { io.WriteString(conn, s+"\r\n") }
+
+		var s smtpSession
+		reply("220 localhost ESMTP test")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			cmd := strings.ToUpper(line)
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"):
+				reply("250-localhost")
+				reply("250 AUTH PLAIN")
+			case strings.HasPrefix(cmd, "AUTH PLAIN"):
+				s.auth = strings.TrimSpace(line[len("AUTH PLAIN"):])
+				reply("235 2.7.0 Authentication successful")
+			case strings.HasPrefix(cmd, "MAIL FROM:"):
+				s.from = line[len("MAIL FROM:"):]
+				reply("250 OK")
+			case strings.HasPrefix(cmd, "RCPT TO:"):
+				s.to = append(s.to, line[len("RCPT TO:"):])
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				var b strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					b.WriteString(l)
+				}
+				s.data = b.String()
+				reply("250 OK")
+			case cmd == "QUIT":
+				reply("221 Bye")
+				ch <- s
+				return
+			default:
+				reply("502 Command not implemented")
+			}
+		}
+	}()
+
+	host, port, _ = net.SplitHostPort(ln.Addr().String())
+	return host, port, ch
+}
+
+func TestSMTPChannel(t *testing.T) {
+	deliveries := recordDeliveries(t)
+	host, port, result := fakeSMTPServer(t)
+
+	ch, err := newNotifyChannel(notifyChannelConfig{
+		Name: "mail",
+		Type: "smtp",
+		SMTP: smtpConfig{
+			Host:     host,
+			Port:     port,
+			Username: "alert@example.com",
+			Password: "secret",
+			To:       []string{"ops@example.com", "dev@example.com"},
+		},
+		Template: "{{range .Alerts}}{{.Host}} {{.Summary}}\n{{end}}",
+		Timeout:  "5s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch.deliver(context.Background(), testMessage())
+
+	var s smtpSession
+	select {
+	case s = <-result:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SMTP 服务器没有收到邮件")
+	}
+
+	if want := base64.StdEncoding.EncodeToString([]byte("\x00alert@example.com\x00secret")); s.auth != want {
+		t.Errorf("AUTH PLAIN = %q，期望 %q", s.auth, want)
+	}
+	if s.from != "<alert@example.com>" {
+		t.Errorf("MAIL FROM = %q", s.from)
+	}
+	if strings.Join(s.to, ",") != "<ops@example.com>,<dev@example.com>" {
+		t.Errorf("RCPT TO = %v", s.to)
+	}
+
+	header, body, ok := strings.Cut(s.data, "\r\n\r\n")
+	if !ok {
+		t.Fatalf("邮件缺少正文: %q", s.data)
+	}
+	subject := "Subject: =?UTF-8?B?" + base64.StdEncoding.EncodeToString([]byte("[FIRING] cpu_high (2)")) + "?="
+	for _, want := range []string{"From: alert@example.com", "To: ops@example.com, dev@example.com", subject} {
+		if !strings.Contains(header, want+"\r\n") {
+			t.Errorf("邮件头缺少 %q: %q", want, header)
+		}
+	}
+	text, err := base64.StdEncoding.DecodeString(strings.TrimSpace(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "web-1 cpu 91\nweb-2 cpu 97\n"; string(text) != want {
+		t.Errorf("邮件正文 = %q，期望 %q", text, want)
+	}
+
+	if records := deliveries(); len(records) != 1 || records[0].Status != "sent" || records[0].Channel != "mail" {
+		t.Errorf("发送记录不符: %+v", records)
+	}
+}
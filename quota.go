@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// 月流量配额跟踪
+// 根据按小时汇总的网络流量 (net_interface_collect_hours) 计算账期内已用流量，
+// 预测账期结束时的总流量，并在达到配置的百分比时输出告警。
+
+const gb = 1024 * 1024 * 1024 // 配额按 GiB 计算
+
+// quotaConfig 单个主机/网卡的月流量配额配置
+type quotaConfig struct {
+	Host         string    `json:"host"`          // 主机名
+	Interface    string    `json:"interface"`     // 网卡接口名，为空时统计该主机全部网卡
+	BillingDay   int       `json:"billing_day"`   // 每月账单日（1-28），默认 1
+	LimitGB      float64   `json:"limit_gb"`      // 账期流量上限（GB）
+	Direction    string    `json:"direction"`     // 计费方向: rx、tx、both（默认，收发合计）、max（收发取大）
+	WarnPercents []float64 `json:"warn_percents"` // 告警百分比，默认 80、90、100
+}
+
+// TrafficQuotaStatus 保存每个账期的流量配额使用情况
+type TrafficQuotaStatus struct {
+	ID               uint      `gorm:"primaryKey;autoIncrement" json:"id"`                                      // 数据库主键
+	Host             string    `gorm:"type:varchar(100);not null;uniqueIndex:idx_quota_period" json:"host"`     // 主机名
+	Interface        string    `gorm:"type:varchar(50);not null;uniqueIndex:idx_quota_period" json:"interface"` // 网卡接口名，为空表示全部网卡
+	PeriodStart      time.Time `gorm:"not null;uniqueIndex:idx_quota_period" json:"period_start"`               // 账期开始时间
+	PeriodEnd        time.Time `gorm:"not null" json:"period_end"`                                              // 账期结束时间
+	Direction        string    `gorm:"type:varchar(10);not null" json:"direction"`                              // 计费方向
+	UsedBytes        int64     `gorm:"not null" json:"used_bytes"`                                              // 账期内已用流量（字节）
+	ProjectedBytes   int64     `gorm:"not null" json:"projected_bytes"`                                         // 预测账期结束时的流量（字节）
+	LimitBytes       int64     `gorm:"not null" json:"limit_bytes"`                                             // 流量上限（字节）
+	UsedPercent      float64   `gorm:"type:decimal(10,2);not null" json:"used_percent"`                         // 已用百分比
+	ProjectedPercent float64   `gorm:"type:decimal(10,2);not null" json:"projected_percent"`                    // 预测百分比
+	WarnedPercent    float64   `gorm:"type:decimal(10,2);not null;default:0" json:"warned_percent"`             // 已触发的最高告警百分比
+	ProjectedWarned  bool      `gorm:"not null;default:false" json:"projected_warned"`                          // 是否已发出预测超额告警
+	UpdatedAt        time.Time `json:"updated_at"`                                                              // 记录更新时间
+}
+
+// TableName 指定 TrafficQuotaStatus 的表名
+func (TrafficQuotaStatus) TableName() string {
+	return "traffic_quota_status"
+}
+
+// billingPeriod 根据账单日计算 now 所在账期的起止时间
+// 账单日超过 28 时按 28 处理，避免短月份没有对应日期
+func billingPeriod(now time.Time, day int) (time.Time, time.Time) {
+	if day < 1 {
+		day = 1
+	}
+	if day > 28 {
+		day = 28
+	}
+	start := time.Date(now.Year(), now.Month(), day, 0, 0, 0, 0, now.Location())
+	if start.After(now) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start, start.AddDate(0, 1, 0)
+}
+
+// quotaUsageRow 流量汇总查询结果
+type quotaUsageRow struct {
+	Recv     int64
+	Sent     int64
+	LegacyMB int64
+}
+
+// fetchQuotaUsage 汇总账期内的收发流量
+// 旧版本的小时数据没有分方向字段，只有 Total (MB)，见 directionBytes
+func fetchQuotaUsage(q quotaConfig, start, end time.Time) (quotaUsageRow, error) {
+	var row quotaUsageRow
+	tx := db.Model(&NetInterfaceCollectHour{}).
+		Select("COALESCE(SUM(recv_bytes), 0) AS recv, "+
+			"COALESCE(SUM(sent_bytes), 0) AS sent, "+
+			"COALESCE(SUM(CASE WHEN recv_bytes = 0 AND sent_bytes = 0 THEN total ELSE 0 END), 0) AS legacy_mb").
+		Where("host = ? AND hour >= ? AND hour < ?", q.Host, start, end)
+	if q.Interface != "" {
+		tx = tx.Where("interface = ?", q.Interface)
+	}
+	err := tx.Scan(&row).Error
+	return row, err
+}
+
+// directionBytes 按计费方向计算已用流量
+// 旧版本的小时数据无法区分收发方向，按收发合计计入每个方向，宁可提前告警也不漏算
+func directionBytes(direction string, usage quotaUsageRow) int64 {
+	legacy := usage.LegacyMB * 1024 * 1024
+	switch direction {
+	case "rx":
+		return usage.Recv + legacy
+	case "tx":
+		return usage.Sent + legacy
+	case "max":
+		if usage.Recv > usage.Sent {
+			return usage.Recv + legacy
+		}
+		return usage.Sent + legacy
+	default:
+		return usage.Recv + usage.Sent + legacy
+	}
+}
+
+// evaluateQuota 计算单个配额在 now 所在账期的使用情况
+func evaluateQuota(q quotaConfig, now time.Time) (TrafficQuotaStatus, error) {
+	start, end := billingPeriod(now, q.BillingDay)
+	usage, err := fetchQuotaUsage(q, start, end)
+	if err != nil {
+		return TrafficQuotaStatus{}, err
+	}
+
+	direction := q.Direction
+	if direction == "" {
+		direction = "both"
+	}
+	used := directionBytes(direction, usage)
+
+	// 按已过去的时间线性外推，账期开始不足一小时时不外推
+	projected := used
+	if elapsed := now.Sub(start); elapsed > time.Hour {
+		projected = int64(float64(used) * float64(end.Sub(start)) / float64(elapsed))
+	}
+
+	limit := int64(q.LimitGB * gb)
+	status := TrafficQuotaStatus{
+		Host:           q.Host,
+		Interface:      q.Interface,
+		PeriodStart:    start,
+		PeriodEnd:      end,
+		Direction:      direction,
+		UsedBytes:      used,
+		ProjectedBytes: projected,
+		LimitBytes:     limit,
+	}
+	if limit > 0 {
+		status.UsedPercent = roundPercent(float64(used) / float64(limit) * 100)
+		status.ProjectedPercent = roundPercent(float64(projected) / float64(limit) * 100)
+	}
+	return status, nil
+}
+
+// roundPercent 百分比保留两位小数
+func roundPercent(v float64) float64 {
+	return float64(int64(v*100+0.5)) / 100
+}
+
+// quotaWarnPercents 返回升序排列的告警百分比
+func quotaWarnPercents(q quotaConfig) []float64 {
+	percents := q.WarnPercents
+	if len(percents) == 0 {
+		percents = []float64{80, 90, 100}
+	}
+	sorted := append([]float64(nil), percents...)
+	sort.Float64s(sorted)
+	return sorted
+}
+
+// checkTrafficQuotas 定时任务，更新所有配额的使用情况并在越过阈值时告警，ctx 取消后停止
+func checkTrafficQuotas(ctx context.Context) error {
+	if len(conf().Quotas) == 0 {
+		return nil
+	}
+	cronLog.Info("checkTrafficQuotas 执行中")
+	now := time.Now()
+
+	for _, q := range conf().Quotas {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if q.Host == "" || q.LimitGB <= 0 {
+			cronLog.Warn("忽略无效的流量配额配置", "host", q.Host, "interface", q.Interface, "limit_gb", q.LimitGB)
+			continue
+		}
+		current, err := evaluateQuota(q, now)
+		if err != nil {
+			cronLog.Error("计算流量配额失败", "host", q.Host, "interface", q.Interface, "err", err)
+			continue
+		}
+
+		// 读取当前账期已保存的状态，保留已告警的阈值
+		var status TrafficQuotaStatus
+		if err := db.WithContext(ctx).Where("host = ? AND interface = ? AND period_start = ?",
+			current.Host, current.Interface, current.PeriodStart).
+			FirstOrInit(&status).Error; err != nil {
+			cronLog.Error("读取流量配额状态失败", "host", q.Host, "interface", q.Interface, "err", err)
+			continue
+		}
+		current.ID = status.ID
+		current.WarnedPercent = status.WarnedPercent
+		current.ProjectedWarned = status.ProjectedWarned
+
+		for _, p := range quotaWarnPercents(q) {
+			if current.UsedPercent >= p && p > current.WarnedPercent {
+				current.WarnedPercent = p
+			}
+		}
+		if current.WarnedPercent > status.WarnedPercent {
+			cronLog.Warn("流量配额告警",
+				"host", q.Host, "interface", quotaInterfaceName(q),
+				"used_percent", current.UsedPercent,
+				"used_gb", float64(current.UsedBytes)/gb, "limit_gb", q.LimitGB,
+				"projected_gb", float64(current.ProjectedBytes)/gb)
+		}
+		if current.ProjectedPercent >= 100 && !current.ProjectedWarned {
+			current.ProjectedWarned = true
+			cronLog.Warn("流量配额预测告警: 预计账期结束时超过上限",
+				"host", q.Host, "interface", quotaInterfaceName(q),
+				"projected_gb", float64(current.ProjectedBytes)/gb, "limit_gb", q.LimitGB)
+		}
+
+		if err := db.WithContext(ctx).Save(&current).Error; err != nil {
+			cronLog.Error("保存流量配额状态失败", "host", q.Host, "interface", q.Interface, "err", err)
+		}
+	}
+	return nil
+}
+
+// quotaInterfaceName 用于日志输出的网卡名称
+func quotaInterfaceName(q quotaConfig) string {
+	if q.Interface == "" {
+		return "*"
+	}
+	return q.Interface
+}
+
+// handleQuota 返回所有流量配额在当前账期的实时使用情况
+func handleQuota(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "只接受 GET 请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	now := time.Now()
+	result := make([]TrafficQuotaStatus, 0, len(conf().Quotas))
+	for _, q := range conf().Quotas {
+		if q.Host == "" || q.LimitGB <= 0 {
+			continue
+		}
+		status, err := evaluateQuota(q, now)
+		if err != nil {
+			http.Error(w, "查询流量配额失败", http.StatusInternalServerError)
+			apiLog.Error("查询流量配额失败", "host", q.Host, "interface", q.Interface, "err", err)
+			return
+		}
+		result = append(result, status)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
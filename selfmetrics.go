@@ -0,0 +1,403 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 采集服务自身的运行指标
+// 包括写入/拒绝/解析失败计数、数据库写入耗时、通知队列长度、定时任务耗时和最近成功时间等，
+// 通过 /metrics 以 Prometheus 文本格式暴露，也可以定时写入 monitorcollect_metrics 表（measurement 为 monitorcollect）。
+
+// selfMetricsConfig 自身指标配置
+type selfMetricsConfig struct {
+	Store         bool   `json:"store"`          // 是否定时写入 monitorcollect_metrics 表
+	StoreInterval string `json:"store_interval"` // 写入间隔，默认 1m
+}
+
+// SelfMetric 写回数据库的自身指标，measurement 为 monitorcollect
+type SelfMetric struct {
+	ID        uint    `gorm:"primaryKey;autoIncrement"`                       // 数据库主键
+	Host      string  `gorm:"type:varchar(100);not null;index:idx_self_host"` // 采集服务所在主机
+	Metric    string  `gorm:"type:varchar(100);not null;index"`               // 指标名称
+	Labels    string  `gorm:"type:varchar(255);not null"`                     // 标签，如 endpoint=json,measurement=cpu
+	Value     float64 `gorm:"type:double;not null"`                           // 指标值
+	Timestamp int64   `gorm:"not null;index:idx_self_host"`                   // 时间戳（秒）
+}
+
+// TableName 指定 SelfMetric 的表名
+func (SelfMetric) TableName() string {
+	return "monitorcollect_metrics"
+}
+
+const (
+	metricCounter   = "counter"
+	metricGauge     = "gauge"
+	metricHistogram = "histogram"
+)
+
+// 默认耗时分桶（秒）
+var defaultDurationBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// metricSeries 一组标签值对应的数据
+type metricSeries struct {
+	labels []string
+	value  float64  // counter、gauge 的值
+	counts []uint64 // histogram 每个分桶的计数（不累加）
+	sum    float64
+	count  uint64
+}
+
+// metricVec 同名指标，按标签值区分序列
+type metricVec struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	buckets    []float64      // 仅 histogram
+	fn         func() float64 // 不为空时为实时计算的 gauge
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+// with 返回标签值对应的序列，不存在时创建，调用方需持有 mu
+// 标签值个数不对时记录错误并返回 nil，调用方丢弃这次更新
+func (m *metricVec) with(labels []string) *metricSeries {
+	if len(labels) != len(m.labelNames) {
+		serverLog.Error("指标标签值个数不正确，已丢弃", "metric", m.name, "want", len(m.labelNames), "got", len(labels))
+		return nil
+	}
+	key := strings.Join(labels, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &metricSeries{labels: append([]string(nil), labels...)}
+		if m.kind == metricHistogram {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// inc counter 加 1
+func (m *metricVec) inc(labels ...string) {
+	m.add(1, labels...)
+}
+
+// add counter 增加 v
+func (m *metricVec) add(v float64, labels ...string) {
+	m.mu.Lock()
+	if s := m.with(labels); s != nil {
+		s.value += v
+	}
+	m.mu.Unlock()
+}
+
+// set 设置 gauge 的值
+func (m *metricVec) set(v float64, labels ...string) {
+	m.mu.Lock()
+	if s := m.with(labels); s != nil {
+		s.value = v
+	}
+	m.mu.Unlock()
+}
+
+// observe histogram 记录一次观测值
+func (m *metricVec) observe(v float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.with(labels)
+	if s == nil {
+		return
+	}
+	for i, upper := range m.buckets {
+		if v <= upper {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// metricRegistry 所有自身指标
+type metricRegistry struct {
+	mu      sync.Mutex
+	metrics []*metricVec
+}
+
+var selfMetrics = &metricRegistry{}
+
+// register 注册指标
+func (r *metricRegistry) register(m *metricVec) *metricVec {
+	m.series = make(map[string]*metricSeries)
+	r.mu.Lock()
+	r.metrics = append(r.metrics, m)
+	r.mu.Unlock()
+	return m
+}
+
+func newCounter(name, help string, labelNames ...string) *metricVec {
+	return selfMetrics.register(&metricVec{name: name, help: help, kind: metricCounter, labelNames: labelNames})
+}
+
+func newGauge(name, help string, labelNames ...string) *metricVec {
+	return selfMetrics.register(&metricVec{name: name, help: help, kind: metricGauge, labelNames: labelNames})
+}
+
+func newGaugeFunc(name, help string, fn func() float64) *metricVec {
+	return selfMetrics.register(&metricVec{name: name, help: help, kind: metricGauge, fn: fn})
+}
+
+func newHistogram(name, help string, buckets []float64, labelNames ...string) *metricVec {
+	return selfMetrics.register(&metricVec{name: name, help: help, kind: metricHistogram, buckets: buckets, labelNames: labelNames})
+}
+
+var (
+	ingestedMetrics = newCounter("moc_ingested_metrics_total", "已接收的指标条数", "endpoint", "measurement")
+	rejectedWrites  = newCounter("moc_rejected_requests_total", "被拒绝的写入请求数", "endpoint", "status")
+	parseErrors     = newCounter("moc_parse_errors_total", "无法解析的写入请求数", "endpoint")
+	dedupedMetrics  = newCounter("moc_ingest_deduplicated_total", "已存在而被忽略的重复指标条数", "measurement")
+	droppedMetrics  = newCounter("moc_ingest_dropped_metrics_total", "写入时丢弃的指标条数（时间戳超出范围或被写入规则过滤）", "endpoint", "reason")
+	fieldErrors     = newCounter("moc_field_errors_total", "字段转换失败的指标条数", "measurement")
+	dbWriteDuration = newHistogram("moc_db_write_duration_seconds", "数据库写入耗时", defaultDurationBuckets, "operation", "table")
+	dbWriteErrors   = newCounter("moc_db_write_errors_total", "数据库写入失败次数", "operation", "table")
+	jobDuration     = newHistogram("moc_cron_job_duration_seconds", "定时任务执行耗时", defaultDurationBuckets, "job")
+	jobLastSuccess  = newGauge("moc_cron_job_last_success_timestamp_seconds", "定时任务最近一次成功完成的时间", "job")
+	jobFailures     = newCounter("moc_cron_job_failures_total", "定时任务失败次数", "job")
+	jobSkipped      = newCounter("moc_cron_job_skipped_total", "任务因上次执行尚未结束而跳过的次数", "job")
+	startTime       = time.Now()
+
+	_ = newGaugeFunc("moc_notify_queue_depth", "通知队列中等待合并的告警数", func() float64 {
+		d := dispatcher.Load()
+		if d == nil {
+			return 0
+		}
+		return float64(len(d.queue))
+	})
+	_ = newGaugeFunc("moc_uptime_seconds", "服务运行时间", func() float64 {
+		return time.Since(startTime).Seconds()
+	})
+	_ = newGaugeFunc("moc_cluster_leader", "当前实例是否执行全局任务（1 为是），未启用 cluster 时始终为 1", func() float64 {
+		if isLeader() {
+			return 1
+		}
+		return 0
+	})
+)
+
+// knownMeasurements 会保存的测量名称，其余名称来自客户端，统一记为 other 以免标签无限增长
+var knownMeasurements = map[string]bool{"cpu": true, "mem": true, "disk": true, "net": true}
+
+// measurementLabel 返回用作指标标签的测量名称
+func measurementLabel(name string) string {
+	if knownMeasurements[name] {
+		return name
+	}
+	return "other"
+}
+
+// countIngested 记录一批已接收的指标
+func countIngested(endpoint string, metrics []TelegrafJson) {
+	for _, m := range metrics {
+		ingestedMetrics.inc(endpoint, measurementLabel(m.Name))
+	}
+}
+
+// countRejected 记录一次被拒绝的写入
+func countRejected(endpoint string, status int) {
+	rejectedWrites.inc(endpoint, strconv.Itoa(status))
+}
+
+const dbStartKey = "moc:start"
+
+// registerDBMetrics 通过 GORM 回调统计写入耗时和失败次数
+func registerDBMetrics(db *gorm.DB) error {
+	before := func(tx *gorm.DB) {
+		tx.InstanceSet(dbStartKey, time.Now())
+	}
+	after := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			v, ok := tx.InstanceGet(dbStartKey)
+			if !ok {
+				return
+			}
+			table := tx.Statement.Table
+			dbWriteDuration.observe(time.Since(v.(time.Time)).Seconds(), operation, table)
+			if tx.Error != nil {
+				dbWriteErrors.inc(operation, table)
+			}
+		}
+	}
+
+	callbacks := db.Callback()
+	if err := callbacks.Create().Before("gorm:create").Register("moc:create_start", before); err != nil {
+		return err
+	}
+	if err := callbacks.Create().After("gorm:create").Register("moc:create_end", after("create")); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("gorm:update").Register("moc:update_start", before); err != nil {
+		return err
+	}
+	if err := callbacks.Update().After("gorm:update").Register("moc:update_end", after("update")); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().Before("gorm:delete").Register("moc:delete_start", before); err != nil {
+		return err
+	}
+	return callbacks.Delete().After("gorm:delete").Register("moc:delete_end", after("delete"))
+}
+
+// selfSample 指标快照中的一个值
+type selfSample struct {
+	name   string
+	labels []string // name=value
+	value  float64
+}
+
+// snapshot 返回所有指标的当前值，histogram 只包含 _sum 和 _count
+func (r *metricRegistry) snapshot() []selfSample {
+	r.mu.Lock()
+	metrics := append([]*metricVec(nil), r.metrics...)
+	r.mu.Unlock()
+
+	var samples []selfSample
+	for _, m := range metrics {
+		if m.fn != nil {
+			samples = append(samples, selfSample{name: m.name, value: m.fn()})
+			continue
+		}
+		m.mu.Lock()
+		for _, s := range m.series {
+			labels := make([]string, len(s.labels))
+			for i, v := range s.labels {
+				labels[i] = m.labelNames[i] + "=" + v
+			}
+			if m.kind == metricHistogram {
+				samples = append(samples,
+					selfSample{name: m.name + "_sum", labels: labels, value: s.sum},
+					selfSample{name: m.name + "_count", labels: labels, value: float64(s.count)})
+				continue
+			}
+			samples = append(samples, selfSample{name: m.name, labels: labels, value: s.value})
+		}
+		m.mu.Unlock()
+	}
+	return samples
+}
+
+// escapeLabelValue 转义 Prometheus 标签值
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// formatLabels 生成 {a="1",b="2"}，extra 为追加的标签（如 le）
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(names)+len(extra)/2)
+	for i, name := range names {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, name, escapeLabelValue(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, extra[i], escapeLabelValue(extra[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// formatFloat 按 Prometheus 文本格式输出数值
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// writePrometheus 以 Prometheus 文本格式输出所有指标
+func (r *metricRegistry) writePrometheus(w io.Writer) {
+	r.mu.Lock()
+	metrics := append([]*metricVec(nil), r.metrics...)
+	r.mu.Unlock()
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name < metrics[j].name })
+
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		if m.fn != nil {
+			fmt.Fprintf(w, "%s %s\n", m.name, formatFloat(m.fn()))
+			continue
+		}
+
+		m.mu.Lock()
+		keys := make([]string, 0, len(m.series))
+		for k := range m.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s := m.series[k]
+			if m.kind != metricHistogram {
+				fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labelNames, s.labels), formatFloat(s.value))
+				continue
+			}
+			var cumulative uint64
+			for i, upper := range m.buckets {
+				cumulative += s.counts[i]
+				fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(m.labelNames, s.labels, "le", formatFloat(upper)), cumulative)
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(m.labelNames, s.labels, "le", "+Inf"), s.count)
+			fmt.Fprintf(w, "%s_sum%s %s\n", m.name, formatLabels(m.labelNames, s.labels), formatFloat(s.sum))
+			fmt.Fprintf(w, "%s_count%s %d\n", m.name, formatLabels(m.labelNames, s.labels), s.count)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// handleSelfMetrics 以 Prometheus 文本格式输出自身指标
+func handleSelfMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "只接受 GET 请求", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	selfMetrics.writePrometheus(w)
+}
+
+// storeSelfMetrics 定时任务，将自身指标写入 monitorcollect_metrics 表
+func storeSelfMetrics() error {
+	host, _ := os.Hostname()
+	now := time.Now().Unix()
+	samples := selfMetrics.snapshot()
+	records := make([]SelfMetric, 0, len(samples))
+	for _, s := range samples {
+		records = append(records, SelfMetric{
+			Host:      host,
+			Metric:    s.name,
+			Labels:    strings.Join(s.labels, ","),
+			Value:     s.value,
+			Timestamp: now,
+		})
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	if err := db.CreateInBatches(records, 200).Error; err != nil {
+		return fmt.Errorf("保存自身指标失败: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// 告警静默和维护窗口
+// 静默通过 API 或命令行创建，包含标签匹配条件和起止时间；维护窗口在配置文件中以 cron 表达式定义开始时间。
+// 告警匹配到静默或处于维护窗口时不发送通知，并在 alert_history 中记录一条 suppressed 记录。
+// 匹配条件与告警规则的 on 子句语法相同，除告警标签外还可以匹配 rule 和 severity。
+
+const alertStateSuppressed = "suppressed"
+
+// maintenanceWindowConfig 周期性维护窗口配置
+type maintenanceWindowConfig struct {
+	Name     string `json:"name"`     // 窗口名称
+	Schedule string `json:"schedule"` // 窗口开始时间（标准 5 段 cron 表达式）
+	Duration string `json:"duration"` // 窗口持续时间，如 2h
+	Matchers string `json:"matchers"` // 标签匹配条件，为空时匹配全部告警
+}
+
+// Silence 告警静默
+type Silence struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`          // 数据库主键
+	Matchers  string    `gorm:"type:varchar(1024);not null" json:"matchers"` // 标签匹配条件，如 host=pi4-2gb,rule=host_down
+	StartsAt  time.Time `gorm:"not null;index" json:"starts_at"`             // 开始时间
+	EndsAt    time.Time `gorm:"not null;index" json:"ends_at"`               // 结束时间
+	CreatedBy string    `gorm:"type:varchar(100)" json:"created_by"`         // 创建人
+	Comment   string    `gorm:"type:varchar(255)" json:"comment"`            // 说明
+	CreatedAt time.Time `json:"created_at"`                                  // 记录创建时间
+}
+
+// TableName 指定 Silence 的表名
+func (Silence) TableName() string {
+	return "silences"
+}
+
+// activeSilence 内存中解析后的静默
+type activeSilence struct {
+	silence  Silence
+	matchers []labelMatcher
+}
+
+// maintenanceWindow 解析后的维护窗口
+type maintenanceWindow struct {
+	name     string
+	schedule cron.Schedule
+	duration time.Duration
+	matchers []labelMatcher
+}
+
+// active 判断 now 是否处于维护窗口内：最近一次开始时间加上持续时间晚于 now
+func (w *maintenanceWindow) active(now time.Time) bool {
+	start := w.schedule.Next(now.Add(-w.duration))
+	return !start.After(now)
+}
+
+// silenceRegistry 保存未结束的静默和维护窗口
+type silenceRegistry struct {
+	mu       sync.Mutex
+	silences []activeSilence
+	windows  []*maintenanceWindow
+}
+
+var silences = &silenceRegistry{}
+
+// initSilences 解析维护窗口配置并加载未结束的静默
+func initSilences() error {
+	var windows []*maintenanceWindow
+	for _, cfg := range conf().Maintenance {
+		w, err := parseMaintenanceWindow(cfg)
+		if err != nil {
+			return fmt.Errorf("维护窗口 %q 无效: %v", cfg.Name, err)
+		}
+		windows = append(windows, w)
+	}
+	silences.mu.Lock()
+	silences.windows = windows
+	silences.mu.Unlock()
+	return reloadSilences()
+}
+
+// parseMaintenanceWindow 解析单个维护窗口
+func parseMaintenanceWindow(cfg maintenanceWindowConfig) (*maintenanceWindow, error) {
+	schedule, err := cron.ParseStandard(cfg.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("无法解析 schedule: %v", err)
+	}
+	duration, err := time.ParseDuration(cfg.Duration)
+	if err != nil || duration <= 0 {
+		return nil, fmt.Errorf("无法解析 duration: %q", cfg.Duration)
+	}
+	matchers, err := parseLabelMatchers(cfg.Matchers)
+	if err != nil {
+		return nil, err
+	}
+	return &maintenanceWindow{name: cfg.Name, schedule: schedule, duration: duration, matchers: matchers}, nil
+}
+
+// reloadSilences 从数据库重新加载未结束的静默
+func reloadSilences() error {
+	var records []Silence
+	if err := db.Where("ends_at > ?", time.Now()).Find(&records).Error; err != nil {
+		return fmt.Errorf("读取告警静默失败: %v", err)
+	}
+
+	var list []activeSilence
+	for _, s := range records {
+		matchers, err := parseLabelMatchers(s.Matchers)
+		if err != nil {
+			alertLog.Warn("忽略无效的告警静默", "id", s.ID, "err", err)
+			continue
+		}
+		list = append(list, activeSilence{silence: s, matchers: matchers})
+	}
+
+	silences.mu.Lock()
+	silences.silences = list
+	silences.mu.Unlock()
+	return nil
+}
+
+// refreshSilences 定时任务，同步其他途径创建的静默
+func refreshSilences() error {
+	return reloadSilences()
+}
+
+// suppressedBy 返回抑制该告警的静默或维护窗口，未被抑制时返回空字符串
+func (r *silenceRegistry) suppressedBy(a *Alert, now time.Time) string {
+	labels := make(map[string]string, len(a.Labels)+2)
+	for k, v := range a.Labels {
+		labels[k] = v
+	}
+	labels["rule"] = a.Rule
+	labels["severity"] = a.Severity
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.silences {
+		if now.Before(s.silence.StartsAt) || !now.Before(s.silence.EndsAt) {
+			continue
+		}
+		if matchLabels(s.matchers, labels) {
+			return fmt.Sprintf("静默 #%d (%s)", s.silence.ID, s.silence.Matchers)
+		}
+	}
+	for _, w := range r.windows {
+		if w.active(now) && matchLabels(w.matchers, labels) {
+			return fmt.Sprintf("维护窗口 %s", w.name)
+		}
+	}
+	return ""
+}
+
+// createSilence 校验并保存静默
+func createSilence(s *Silence) error {
+	if _, err := parseLabelMatchers(s.Matchers); err != nil {
+		return err
+	}
+	if s.Matchers == "" {
+		return fmt.Errorf("缺少 matchers")
+	}
+	if s.StartsAt.IsZero() {
+		s.StartsAt = time.Now()
+	}
+	if !s.EndsAt.After(s.StartsAt) {
+		return fmt.Errorf("ends_at 必须晚于 starts_at")
+	}
+	if err := db.Create(s).Error; err != nil {
+		return err
+	}
+	return reloadSilences()
+}
+
+// expireSilence 将静默的结束时间设置为当前时间
+func expireSilence(id uint) error {
+	result := db.Model(&Silence{}).Where("id = ? AND ends_at > ?", id, time.Now()).Update("ends_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("静默 #%d 不存在或已结束", id)
+	}
+	return reloadSilences()
+}
+
+// silenceRequest 创建静默的请求体，duration 与 ends_at 二选一
+type silenceRequest struct {
+	Matchers  string    `json:"matchers"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	Duration  string    `json:"duration"`
+	CreatedBy string    `json:"created_by"`
+	Comment   string    `json:"comment"`
+}
+
+// toSilence 转换为 Silence
+func (req silenceRequest) toSilence() (*Silence, error) {
+	s := &Silence{
+		Matchers:  req.Matchers,
+		StartsAt:  req.StartsAt,
+		EndsAt:    req.EndsAt,
+		CreatedBy: req.CreatedBy,
+		Comment:   req.Comment,
+	}
+	if s.StartsAt.IsZero() {
+		s.StartsAt = time.Now()
+	}
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("无法解析 duration: %v", err)
+		}
+		s.EndsAt = s.StartsAt.Add(d)
+	}
+	return s, nil
+}
+
+// handleSilences 管理告警静默
+// GET 查询未结束的静默（all=1 查询全部），POST 创建静默，DELETE ?id= 提前结束静默
+func handleSilences(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		var result []Silence
+		tx := db.Order("id DESC").Limit(queryLimit(r))
+		if r.URL.Query().Get("all") != "1" {
+			tx = tx.Where("ends_at > ?", time.Now())
+		}
+		if err := tx.Find(&result).Error; err != nil {
+			http.Error(w, "查询告警静默失败", http.StatusInternalServerError)
+			apiLog.Error("查询告警静默失败", "err", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+
+	case http.MethodPost:
+		var req silenceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "无法解析请求体", http.StatusBadRequest)
+			return
+		}
+		s, err := req.toSilence()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := createSilence(s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusCreated, s)
+
+	case http.MethodDelete:
+		id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "缺少或无效的 id 参数", http.StatusBadRequest)
+			return
+		}
+		if err := expireSilence(uint(id)); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "只接受 GET、POST、DELETE 请求", http.StatusMethodNotAllowed)
+	}
+}
+
+// runSilenceCommand 命令行管理告警静默
+//
+//	silence add -matchers host=pi4-2gb -duration 2h -comment "升级内核"
+//	silence list [-all]
+//	silence expire <id>
+func runSilenceCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("用法: silence add|list|expire")
+	}
+
+	switch args[0] {
+	case "add":
+		fs := flag.NewFlagSet("silence add", flag.ContinueOnError)
+		var req silenceRequest
+		fs.StringVar(&req.Matchers, "matchers", "", "标签匹配条件，如 host=pi4-2gb,rule=host_down")
+		fs.StringVar(&req.Duration, "duration", "1h", "静默持续时间")
+		fs.StringVar(&req.CreatedBy, "created-by", os.Getenv("USER"), "创建人")
+		fs.StringVar(&req.Comment, "comment", "", "说明")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		s, err := req.toSilence()
+		if err != nil {
+			return err
+		}
+		if err := createSilence(s); err != nil {
+			return err
+		}
+		fmt.Printf("已创建静默 #%d，%s 至 %s\n", s.ID, s.StartsAt.Format(time.DateTime), s.EndsAt.Format(time.DateTime))
+		return nil
+
+	case "list":
+		fs := flag.NewFlagSet("silence list", flag.ContinueOnError)
+		all := fs.Bool("all", false, "包含已结束的静默")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		var result []Silence
+		tx := db.Order("id DESC")
+		if !*all {
+			tx = tx.Where("ends_at > ?", time.Now())
+		}
+		if err := tx.Find(&result).Error; err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tMATCHERS\tSTARTS\tENDS\tCREATED_BY\tCOMMENT")
+		for _, s := range result {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", s.ID, s.Matchers,
+				s.StartsAt.Format(time.DateTime), s.EndsAt.Format(time.DateTime), s.CreatedBy, s.Comment)
+		}
+		return tw.Flush()
+
+	case "expire":
+		if len(args) < 2 {
+			return fmt.Errorf("用法: silence expire <id>")
+		}
+		id, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("无效的 id: %s", args[1])
+		}
+		if err := expireSilence(uint(id)); err != nil {
+			return err
+		}
+		fmt.Printf("已结束静默 #%d\n", id)
+		return nil
+
+	default:
+		return fmt.Errorf("未知的 silence 子命令: %s", args[0])
+	}
+}
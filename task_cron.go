@@ -1,68 +1,143 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
-	"log"
+	"sync/atomic"
 	"time"
 
 	"github.com/robfig/cron/v3"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-func TaskRun() {
+// scheduler 当前运行的定时任务调度器，重新加载配置时替换
+var scheduler atomic.Pointer[cron.Cron]
+
+// TaskRun 注册并启动定时任务，返回调度器以便退出时等待正在执行的任务
+func TaskRun() *cron.Cron {
 	// cron test
 	c := cron.New()
-	log.Printf("collectDisposeHour 启动，等待任务调度...")
+	cronLog.Info("定时任务已启动，等待任务调度")
 
-	if config.Cron.Enable {
-		//  使用配置文件  config.Cron.ScheduleDispos
-		_, err := c.AddFunc(config.Cron.ScheduleDispos, collectDisposeHour)
+	if conf().Cron.Enable {
+		//  使用配置文件  conf().Cron.ScheduleDispos
+		_, err := c.AddJob(conf().Cron.ScheduleDispos, scheduledJob("collectDisposeHour"))
 		if err != nil {
-			return
+			return c
 		}
-		_, err = c.AddFunc("2 0 * * *", clearDisk)
+		_, err = c.AddJob("2 0 * * *", scheduledJob("clearDisk"))
 		if err != nil {
-			return
+			return c
 		} // 每天凌晨清理磁盘数据
-		_, err = c.AddFunc("3 1 * * *", clearCpu)
+		_, err = c.AddJob("3 1 * * *", scheduledJob("clearCpu"))
 		if err != nil {
-			return
+			return c
 		} // 每天凌晨1点清理CPU数据
-		_, err = c.AddFunc("4 2 * * *", clearMem)
+		_, err = c.AddJob("4 2 * * *", scheduledJob("clearMem"))
 		if err != nil {
-			return
+			return c
 		} // 每天凌晨2点清理内存数据
+		_, err = c.AddJob("5 3 * * *", scheduledJob("clearNet5Min"))
+		if err != nil {
+			return c
+		} // 每天凌晨3点清理 5 分钟流量数据
+		_, err = c.AddJob("10 * * * *", scheduledJob("checkTrafficQuotas"))
+		if err != nil {
+			return c
+		} // 每小时检查流量配额
+	}
+	_, err := c.AddJob("6 4 * * *", scheduledJob("clearJobRuns"))
+	if err != nil {
+		return c
+	} // 每天凌晨4点清理任务执行记录
+	if conf().Alert.Enable {
+		_, err = c.AddJob("@every 1m", scheduledJob("evaluateAlerts"))
+		if err != nil {
+			return c
+		} // 每分钟推进 pending 告警
+	}
+	_, err = c.AddJob("@every 1m", scheduledJob("flushHeartbeats"))
+	if err != nil {
+		return c
+	} // 每分钟保存主机心跳
+	_, err = c.AddJob("@every 1m", scheduledJob("flushCPUCores"))
+	if err != nil {
+		return c
+	} // 每分钟保存每核 CPU 汇总
+	_, err = c.AddJob("@every 1m", scheduledJob("refreshSilences"))
+	if err != nil {
+		return c
+	} // 每分钟同步告警静默
+	if conf().Heartbeat.Enable {
+		_, err = c.AddJob("@every 1m", scheduledJob("checkHostAbsence"))
+		if err != nil {
+			return c
+		} // 每分钟检查离线主机
+	}
+	if conf().Forecast.Enable {
+		_, err = c.AddJob("20 * * * *", scheduledJob("forecastDiskFull"))
+		if err != nil {
+			return c
+		} // 每小时计算磁盘写满预测
+	}
+	if conf().Anomaly.Enable {
+		_, err = c.AddJob("30 * * * *", scheduledJob("detectAnomalies"))
+		if err != nil {
+			return c
+		} // 每小时检查上一小时的异常
+	}
+	if conf().SelfMetrics.Store {
+		interval := conf().SelfMetrics.StoreInterval
+		if interval == "" {
+			interval = "1m"
+		}
+		_, err = c.AddJob("@every "+interval, scheduledJob("storeSelfMetrics"))
+		if err != nil {
+			return c
+		} // 定时保存自身指标
 	}
 	c.Start()
+	return c
 }
 
 // aggKey 用于聚合数据的键
 type aggKey struct {
 	Host      string
 	Interface string
-	Hour      time.Time
+	Bucket    time.Time // 时间段起点（小时或 5 分钟）
 }
 
 // trafficStats 用于临时存储统计数据
 type trafficStats struct {
-	MinRecv int64
-	MaxRecv int64
-	MinSent int64
-	MaxSent int64
-	Count   int64
+	RecvBytes int64 // 时间段内接收流量（字节）
+	SentBytes int64 // 时间段内发送流量（字节）
+	Seconds   int64 // 流量对应的采集间隔合计（秒）
+	Count     int64
 }
 
+// counterLookback 统计时向前多读取的原始数据时长，用于取得每个时间段之前的最后一个计数器值，
+// 需大于 Telegraf 的采集间隔。collectDisposeHour 删除原始数据时同样保留这段时间
+const counterLookback = 15 * time.Minute
+
 // collectDisposeHour 定时清理任务，清理过期数据、按时间段统计网络流量存储。
-// 按小时统计网络流量数据，存储到对应的表中。
+// 按小时统计网络流量数据，存储到对应的表中，返回处理的原始数据条数。
 // 流量存储单位为 MB，速度为 MB/s
-func collectDisposeHour() {
-	log.Printf("collectDisposeHour 执行中...")
-	// 获取过去 30 天的数据处理起止时间
-	columbina := -24 * 1 * time.Hour
-	startTime := time.Now().Add(columbina).Unix()
-
-	// Start a transaction
-	tx := db.Begin()
+// 每次重新统计最近 24 个完整的小时并覆盖已有结果，迟到的数据在之后的执行中补齐，当前未结束的小时不统计。
+// 手动执行并指定时间段时，只重新统计该时间段并覆盖重新统计出的结果（见 rollupWindow），不删除原始数据和已有的统计结果
+func collectDisposeHour(ctx context.Context, w jobWindow) (int64, error) {
+	if !w.isZero() {
+		result, err := rollupWindow(ctx, w.From, w.To, false)
+		return int64(result.Raw), err
+	}
+	cronLog.Info("collectDisposeHour 执行中")
+	// 过去 24 个完整小时的处理起止时间
+	now := bucketStart(time.Now(), time.Hour)
+	from := now.Add(-24 * time.Hour)
+
+	// Start a transaction，ctx 取消时事务回滚
+	tx := db.WithContext(ctx).Begin()
 	defer func() {
 		if r := recover(); r != nil {
 			tx.Rollback()
@@ -70,26 +145,136 @@ func collectDisposeHour() {
 	}()
 
 	if tx.Error != nil {
-		log.Printf("Failed to start transaction: %v", tx.Error)
-		return
+		return 0, fmt.Errorf("failed to start transaction: %v", tx.Error)
 	}
 
-	// 1. 获取数据
-	rawData, err := fetchRawData(tx, startTime)
+	// 1-4. 获取数据、聚合统计并保存
+	result, err := rollupNetTraffic(tx, from, now, true)
 	if err != nil {
-		log.Printf("Failed to fetch raw data: %v", err)
 		tx.Rollback()
-		return
+		return 0, err
+	}
+	if result.Raw == 0 {
+		cronLog.Info("No data to process.")
+		tx.Rollback()
+		return 0, nil
 	}
 
-	if len(rawData) == 0 {
-		log.Println("No data to process.")
+	// 5. 删除原始数据，保留 counterLookback 供下次统计第一个时间段使用
+	if err := deleteRawData(tx, from.Add(-counterLookback).Unix()); err != nil {
 		tx.Rollback()
-		return
+		return 0, fmt.Errorf("failed to delete processed data: %v", err)
+	}
+
+	// 6. 提交事务
+	if err := tx.Commit().Error; err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	cronLog.Info("collectDisposeHour 成功", "raw", result.Raw, "hour", result.Hour, "five_min", result.FiveMin)
+	return int64(result.Raw), nil
+}
+
+// alignHours 将时间段扩展到整点，避免只统计部分数据的小时覆盖完整的统计结果
+func alignHours(from, to time.Time) (time.Time, time.Time) {
+	from = bucketStart(from, time.Hour)
+	if aligned := bucketStart(to, time.Hour); aligned.Before(to) {
+		to = aligned.Add(time.Hour)
+	}
+	return from, to
+}
+
+// rollupWindow 在一个事务中重新统计指定时间段的网络流量，时间段按小时对齐；ctx 取消时事务回滚。
+// 原始数据只保留约 24 小时，开始时间早于原始数据时从原始数据覆盖的第一个完整小时开始统计，
+// 更早的统计结果保持不变，避免被删除或被不完整的数据覆盖
+func rollupWindow(ctx context.Context, from, to time.Time, replace bool) (rollupResult, error) {
+	from, to = alignHours(from, to)
+	if !from.Before(to) {
+		return rollupResult{}, fmt.Errorf("开始时间必须早于结束时间")
+	}
+	tx := db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return rollupResult{}, tx.Error
+	}
+	clamped, err := clampToRawData(tx, from)
+	if err != nil {
+		tx.Rollback()
+		return rollupResult{From: from, To: to}, err
+	}
+	if clamped.After(from) {
+		cronLog.Warn("原始数据不足，只统计原始数据覆盖的完整小时", "from", from, "raw_from", clamped)
+		from = clamped
+	}
+	if !from.Before(to) {
+		tx.Rollback()
+		return rollupResult{From: from, To: to}, nil
+	}
+	result, err := rollupNetTraffic(tx, from, to, replace)
+	if err != nil {
+		tx.Rollback()
+		return result, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return result, err
+	}
+	cronLog.Info("重新统计网络流量完成", "from", from, "to", to, "raw", result.Raw, "hour", result.Hour, "five_min", result.FiveMin)
+	return result, nil
+}
+
+// rollupResult 一次流量统计处理的数据条数
+type rollupResult struct {
+	From    time.Time // 实际统计的开始时间
+	To      time.Time // 实际统计的结束时间
+	Raw     int       // 原始数据
+	Hour    int       // 写入的小时统计
+	FiveMin int       // 写入的 5 分钟统计
+}
+
+// clampToRawData 返回原始数据能完整统计的第一个小时：from 之前 counterLookback 内有原始数据时为 from，
+// 否则为最早一条原始数据之后的整点（该小时之前的数据可能已被删除）；没有原始数据时返回 from
+func clampToRawData(tx *gorm.DB, from time.Time) (time.Time, error) {
+	var earliest sql.NullInt64
+	err := tx.Model(&NetInterfaceFieldsDb{}).Where("timestamp >= ?", from.Add(-counterLookback).Unix()).
+		Select("MIN(timestamp)").Scan(&earliest).Error
+	if err != nil {
+		return from, fmt.Errorf("查询最早的原始数据失败: %v", err)
+	}
+	if !earliest.Valid || earliest.Int64 < from.Unix() {
+		return from, nil
+	}
+	return bucketStart(time.Unix(earliest.Int64, 0), time.Hour).Add(time.Hour), nil
+}
+
+// rollupNetTraffic 统计 [from, to) 内的原始流量数据，写入小时和 5 分钟统计表。
+// replace 为 true 时先删除该时间段内已有的统计结果，用于重新统计历史时间段，调用方需保证 from、to 按小时对齐
+func rollupNetTraffic(tx *gorm.DB, from, to time.Time, replace bool) (rollupResult, error) {
+	result := rollupResult{From: from, To: to}
+
+	// 1. 获取数据，包括 from 之前 counterLookback 内的数据
+	rawData, err := fetchRawData(tx, from.Add(-counterLookback).Unix(), to.Unix())
+	if err != nil {
+		return result, fmt.Errorf("failed to fetch raw data: %v", err)
+	}
+	for _, record := range rawData {
+		if record.Timestamp >= from.Unix() {
+			result.Raw++
+		}
+	}
+	if result.Raw == 0 {
+		return result, nil
+	}
+
+	if replace {
+		if err := tx.Where("hour >= ? AND hour < ?", from, to).Delete(&NetInterfaceCollectHour{}).Error; err != nil {
+			return result, fmt.Errorf("failed to delete existing hour data: %v", err)
+		}
+		if err := tx.Where("bucket >= ? AND bucket < ?", from, to).Delete(&NetInterfaceCollect5Min{}).Error; err != nil {
+			return result, fmt.Errorf("failed to delete existing 5-minute data: %v", err)
+		}
 	}
 
 	// 2. 聚合统计
-	statsMap := aggregateTrafficStats(rawData)
+	statsMap := aggregateTrafficStats(rawData, from, time.Hour)
 
 	// 3. 转换为目标数据结构
 	hourData := prepareHourData(statsMap)
@@ -97,89 +282,86 @@ func collectDisposeHour() {
 	// 4. 保存统计结果
 	if len(hourData) > 0 {
 		if err := saveHourData(tx, hourData); err != nil {
-			log.Printf("Failed to insert hour data: %v", err)
-			tx.Rollback()
-			return
+			return result, fmt.Errorf("failed to insert hour data: %v", err)
 		}
 	}
+	result.Hour = len(hourData)
 
-	// 5. 删除原始数据
-	if err := deleteRawData(tx, startTime); err != nil {
-		log.Printf("Failed to delete processed data: %v", err)
-		tx.Rollback()
-		return
-	}
-
-	// 6. 提交事务
-	if err := tx.Commit().Error; err != nil {
-		log.Printf("Failed to commit transaction: %v", err)
-		return
+	// 4.1 5 分钟粒度汇总，供 95 计费使用
+	fiveMinData := prepare5MinData(aggregateTrafficStats(rawData, from, 5*time.Minute))
+	if len(fiveMinData) > 0 {
+		if err := save5MinData(tx, fiveMinData); err != nil {
+			return result, fmt.Errorf("failed to insert 5-minute data: %v", err)
+		}
 	}
-
-	log.Printf("collectDisposeHour 成功: 处理了 %d 条原始记录，生成 %d 条小时记录。", len(rawData), len(hourData))
+	result.FiveMin = len(fiveMinData)
+	return result, nil
 }
 
-// fetchRawData 从数据库获取原始数据
-func fetchRawData(tx *gorm.DB, startTime int64) ([]NetInterfaceFieldsDb, error) {
+// fetchRawData 从数据库获取 [startTime, endTime) 内的原始数据，按时间排序
+func fetchRawData(tx *gorm.DB, startTime, endTime int64) ([]NetInterfaceFieldsDb, error) {
 	var rawData []NetInterfaceFieldsDb
-	if err := tx.Where("timestamp > ?", startTime).Find(&rawData).Error; err != nil {
+	if err := tx.Where("timestamp >= ? AND timestamp < ?", startTime, endTime).Order("timestamp").Find(&rawData).Error; err != nil {
 		return nil, err
 	}
 	return rawData, nil
 }
 
-// aggregateTrafficStats 对原始数据进行聚合计算
-func aggregateTrafficStats(rawData []NetInterfaceFieldsDb) map[aggKey]*trafficStats {
+// aggregateTrafficStats 对原始数据按 bucket 时长分段进行聚合计算，rawData 需按时间排序。
+// 每条数据的流量为与同一网卡上一条数据的计数器差值，计入该条数据所在的时间段，
+// 因此跨越时间段边界的流量不会丢失。from 之前的数据只作为计数器的起点，不生成统计结果
+func aggregateTrafficStats(rawData []NetInterfaceFieldsDb, from time.Time, bucket time.Duration) map[aggKey]*trafficStats {
 	statsMap := make(map[aggKey]*trafficStats)
+	type series struct{ Host, Interface string }
+	last := make(map[series]NetInterfaceFieldsDb)
 
 	for _, record := range rawData {
-		ts := time.Unix(record.Timestamp, 0)
-		hourTime := time.Date(ts.Year(), ts.Month(), ts.Day(), ts.Hour(), 0, 0, 0, ts.Location())
+		sk := series{record.Host, record.Interface}
+		prev, hasPrev := last[sk]
+		last[sk] = record
+		if record.Timestamp < from.Unix() {
+			continue
+		}
 
 		k := aggKey{
 			Host:      record.Host,
 			Interface: record.Interface,
-			Hour:      hourTime,
+			Bucket:    bucketStart(time.Unix(record.Timestamp, 0), bucket),
 		}
-
 		if _, exists := statsMap[k]; !exists {
-			statsMap[k] = &trafficStats{
-				MinRecv: record.BytesRecv,
-				MaxRecv: record.BytesRecv,
-				MinSent: record.BytesSent,
-				MaxSent: record.BytesSent,
-				Count:   0,
-			}
+			statsMap[k] = &trafficStats{}
 		}
 
 		s := statsMap[k]
-		if record.BytesRecv < s.MinRecv {
-			s.MinRecv = record.BytesRecv
-		}
-		if record.BytesRecv > s.MaxRecv {
-			s.MaxRecv = record.BytesRecv
+		s.Count++
+		if !hasPrev {
+			continue
 		}
-		if record.BytesSent < s.MinSent {
-			s.MinSent = record.BytesSent
+		// 如果计数器重置，避免负值
+		if d := record.BytesRecv - prev.BytesRecv; d > 0 {
+			s.RecvBytes += d
 		}
-		if record.BytesSent > s.MaxSent {
-			s.MaxSent = record.BytesSent
+		if d := record.BytesSent - prev.BytesSent; d > 0 {
+			s.SentBytes += d
 		}
-
-		s.Count++
+		s.Seconds += record.Timestamp - prev.Timestamp
 	}
 	return statsMap
 }
 
+// bucketStart 按本地时间计算 ts 所在时间段的起点，bucket 不超过一小时
+func bucketStart(ts time.Time, bucket time.Duration) time.Time {
+	hourTime := time.Date(ts.Year(), ts.Month(), ts.Day(), ts.Hour(), 0, 0, 0, ts.Location())
+	return hourTime.Add(ts.Sub(hourTime).Truncate(bucket))
+}
+
 // prepareHourData 将聚合结果转换为数据库模型
 func prepareHourData(statsMap map[aggKey]*trafficStats) []NetInterfaceCollectHour {
 	var hourData []NetInterfaceCollectHour
 	for k, s := range statsMap {
-		totalBytes := (s.MaxRecv - s.MinRecv) + (s.MaxSent - s.MinSent)
-		// 如果计数器重置，避免负值
-		if totalBytes < 0 {
-			totalBytes = 0
-		}
+		recvBytes := s.RecvBytes
+		sentBytes := s.SentBytes
+		totalBytes := recvBytes + sentBytes
 
 		// 计算平均速度 (bits/s)
 		// 1 byte = 8 bits
@@ -195,8 +377,10 @@ func prepareHourData(statsMap map[aggKey]*trafficStats) []NetInterfaceCollectHou
 		item := NetInterfaceCollectHour{
 			Host:      k.Host,
 			Interface: k.Interface,
-			Hour:      k.Hour,
+			Hour:      k.Bucket,
 			Total:     totalMB,
+			RecvBytes: recvBytes,
+			SentBytes: sentBytes,
 			Speed:     float64(int64(avgBps/1000000.0*100+0.5)) / 100.0, // 保留两位小数 (Mbps)
 			SpeedStr:  speedStr,
 		}
@@ -206,10 +390,42 @@ func prepareHourData(statsMap map[aggKey]*trafficStats) []NetInterfaceCollectHou
 	return hourData
 }
 
-// saveHourData 批量保存小时统计数据
+// prepare5MinData 将 5 分钟聚合结果转换为数据库模型，速率单位为 bits/s
+// 速率按流量对应的实际采集间隔计算，采集中断后的第一个时间段按中断时长平均
+func prepare5MinData(statsMap map[aggKey]*trafficStats) []NetInterfaceCollect5Min {
+	var data []NetInterfaceCollect5Min
+	for k, s := range statsMap {
+		item := NetInterfaceCollect5Min{
+			Host:      k.Host,
+			Interface: k.Interface,
+			Bucket:    k.Bucket,
+			RecvBytes: s.RecvBytes,
+			SentBytes: s.SentBytes,
+		}
+		if s.Seconds > 0 {
+			item.RecvBps = float64(s.RecvBytes*8) / float64(s.Seconds)
+			item.SentBps = float64(s.SentBytes*8) / float64(s.Seconds)
+		}
+		data = append(data, item)
+	}
+	return data
+}
+
+// saveHourData 批量保存小时统计数据，同一主机、网卡和小时已有统计结果时覆盖
 func saveHourData(tx *gorm.DB, data []NetInterfaceCollectHour) error {
 	// 按批写入，避免一次性插入大量数据导致内存或事务压力
-	return tx.CreateInBatches(data, 100).Error
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "host"}, {Name: "interface"}, {Name: "hour"}},
+		DoUpdates: clause.AssignmentColumns([]string{"total", "recv_bytes", "sent_bytes", "speed", "speed_str"}),
+	}).CreateInBatches(data, 100).Error
+}
+
+// save5MinData 批量保存 5 分钟统计数据，同一主机、网卡和时间段已有统计结果时覆盖
+func save5MinData(tx *gorm.DB, data []NetInterfaceCollect5Min) error {
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "host"}, {Name: "interface"}, {Name: "bucket"}},
+		DoUpdates: clause.AssignmentColumns([]string{"recv_bytes", "sent_bytes", "recv_bps", "sent_bps"}),
+	}).CreateInBatches(data, 100).Error
 }
 
 // deleteRawData 删除已处理的原始数据,今天之前的数据
@@ -229,41 +445,102 @@ func formatNetSpeed(bps float64) string {
 	return fmt.Sprintf("%.2f bps", bps)
 }
 
-// clearDisk 清理过期磁盘数据
-func clearDisk() {
-	log.Printf("clearDisk 执行中...")
-	columbina := -24 * 30 * time.Hour
-	startTime := time.Now().Add(columbina).Unix()
+// retentionPolicy 数据保留策略
+type retentionPolicy struct {
+	name   string
+	model  interface{}
+	column string        // 时间字段
+	unix   bool          // 时间字段为 Unix 时间戳（秒），否则为时间类型
+	keep   time.Duration // 保留时长
+}
 
-	// 删除过期数据
-	if err := db.Where("timestamp < ?", startTime).Delete(&DiskFieldsDb{}).Error; err != nil {
-		log.Printf("Failed to clear old disk data: %v", err)
-		return
+// 各表的保留策略，原始流量数据在 collectDisposeHour 统计后删除，不在此列
+var (
+	diskRetention    = retentionPolicy{"disk", &DiskFieldsDb{}, "timestamp", true, 30 * 24 * time.Hour}
+	cpuRetention     = retentionPolicy{"cpu", &CPUFieldsDb{}, "timestamp", true, 30 * 24 * time.Hour}
+	memRetention     = retentionPolicy{"mem", &MemFieldsDb{}, "timestamp", true, 30 * 24 * time.Hour}
+	net5MinRetention = retentionPolicy{"net_5min", &NetInterfaceCollect5Min{}, "bucket", false, 90 * 24 * time.Hour}
+	cpuCoreRetention = retentionPolicy{"cpu_cores", &CPUCoreRollup{}, "bucket", false, 90 * 24 * time.Hour}
+	jobRunRetention  = retentionPolicy{"job_runs", &JobRun{}, "started_at", false, 30 * 24 * time.Hour}
+
+	retentionPolicies = []retentionPolicy{diskRetention, cpuRetention, cpuCoreRetention, memRetention, net5MinRetention, jobRunRetention}
+)
+
+// cutoff 早于该时间的数据将被删除
+func (p retentionPolicy) cutoff(now time.Time) time.Time {
+	return now.Add(-p.keep)
+}
+
+// apply 删除超过保留时长的数据并返回删除的条数，dryRun 为 true 时只统计条数
+func (p retentionPolicy) apply(ctx context.Context, now time.Time, dryRun bool) (int64, error) {
+	var cutoff interface{} = p.cutoff(now)
+	if p.unix {
+		cutoff = p.cutoff(now).Unix()
 	}
+	q := db.WithContext(ctx).Where(p.column+" < ?", cutoff)
+	if dryRun {
+		var n int64
+		err := q.Model(p.model).Count(&n).Error
+		return n, err
+	}
+	res := q.Delete(p.model)
+	return res.RowsAffected, res.Error
 }
 
-// clearCpu 清理过期 CPU 数据
-func clearCpu() {
-	log.Printf("clearCpu 执行中...")
-	columbina := -24 * 30 * time.Hour
-	startTime := time.Now().Add(columbina).Unix()
+// clearDisk 清理过期磁盘数据
+func clearDisk(ctx context.Context) (int64, error) {
+	cronLog.Info("clearDisk 执行中")
+	// 删除过期数据
+	n, err := diskRetention.apply(ctx, time.Now(), false)
+	if err != nil {
+		return 0, fmt.Errorf("failed to clear old disk data: %v", err)
+	}
+	return n, nil
+}
 
+// clearCpu 清理过期 CPU 数据和每核汇总
+func clearCpu(ctx context.Context) (int64, error) {
+	cronLog.Info("clearCpu 执行中")
 	// 删除过期数据
-	if err := db.Where("timestamp < ?", startTime).Delete(&CPUFieldsDb{}).Error; err != nil {
-		log.Printf("Failed to clear old CPU data: %v", err)
-		return
+	now := time.Now()
+	n, err := cpuRetention.apply(ctx, now, false)
+	if err != nil {
+		return 0, fmt.Errorf("failed to clear old CPU data: %v", err)
 	}
+	cores, err := cpuCoreRetention.apply(ctx, now, false)
+	if err != nil {
+		return n, fmt.Errorf("failed to clear old CPU core rollups: %v", err)
+	}
+	return n + cores, nil
 }
 
 // clearMem 清理过期内存数据
-func clearMem() {
-	log.Printf("clearMem 执行中...")
-	columbina := -24 * 30 * time.Hour
-	startTime := time.Now().Add(columbina).Unix()
+func clearMem(ctx context.Context) (int64, error) {
+	cronLog.Info("clearMem 执行中")
+	// 删除过期数据
+	n, err := memRetention.apply(ctx, time.Now(), false)
+	if err != nil {
+		return 0, fmt.Errorf("failed to clear old Mem data: %v", err)
+	}
+	return n, nil
+}
 
+// clearNet5Min 清理过期的 5 分钟流量数据，保留 90 天以覆盖完整账期
+func clearNet5Min(ctx context.Context) (int64, error) {
+	cronLog.Info("clearNet5Min 执行中")
 	// 删除过期数据
-	if err := db.Where("timestamp < ?", startTime).Delete(&MemFieldsDb{}).Error; err != nil {
-		log.Printf("Failed to clear old Mem data: %v", err)
-		return
+	n, err := net5MinRetention.apply(ctx, time.Now(), false)
+	if err != nil {
+		return 0, fmt.Errorf("failed to clear old 5-minute net data: %v", err)
+	}
+	return n, nil
+}
+
+// clearJobRuns 清理过期的任务执行记录
+func clearJobRuns(ctx context.Context) (int64, error) {
+	n, err := jobRunRetention.apply(ctx, time.Now(), false)
+	if err != nil {
+		return 0, fmt.Errorf("failed to clear old job runs: %v", err)
 	}
+	return n, nil
 }
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAggregateTrafficStats(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+	sample := func(iface string, offset time.Duration, recv, sent int64) NetInterfaceFieldsDb {
+		return NetInterfaceFieldsDb{Host: "h1", Interface: iface, Timestamp: base.Add(offset).Unix(), BytesRecv: recv, BytesSent: sent}
+	}
+	key := func(iface string, bucket time.Time) aggKey {
+		return aggKey{Host: "h1", Interface: iface, Bucket: bucket}
+	}
+	tests := []struct {
+		name   string
+		raw    []NetInterfaceFieldsDb
+		bucket time.Duration
+		want   map[aggKey]trafficStats
+	}{
+		{
+			name: "差值计入后一条数据所在的时间段",
+			raw: []NetInterfaceFieldsDb{
+				sample("eth0", 55*time.Minute, 1000, 100),
+				sample("eth0", 65*time.Minute, 1600, 400),
+				sample("eth0", 70*time.Minute, 2000, 500),
+			},
+			bucket: time.Hour,
+			want: map[aggKey]trafficStats{
+				key("eth0", base):                {Count: 1},
+				key("eth0", base.Add(time.Hour)): {RecvBytes: 1000, SentBytes: 400, Seconds: 900, Count: 2},
+			},
+		},
+		{
+			name: "计数器重置时不产生负值",
+			raw: []NetInterfaceFieldsDb{
+				sample("eth0", 0, 5000, 5000),
+				sample("eth0", time.Minute, 6000, 5500),
+				sample("eth0", 2*time.Minute, 200, 100),
+				sample("eth0", 3*time.Minute, 700, 300),
+			},
+			bucket: time.Hour,
+			want: map[aggKey]trafficStats{
+				key("eth0", base): {RecvBytes: 1000 + 500, SentBytes: 500 + 200, Seconds: 180, Count: 4},
+			},
+		},
+		{
+			name: "不同网卡分别计算",
+			raw: []NetInterfaceFieldsDb{
+				sample("eth0", 0, 100, 0),
+				sample("eth1", 0, 9000, 0),
+				sample("eth0", time.Minute, 300, 0),
+				sample("eth1", time.Minute, 9100, 0),
+			},
+			bucket: 5 * time.Minute,
+			want: map[aggKey]trafficStats{
+				key("eth0", base): {RecvBytes: 200, Seconds: 60, Count: 2},
+				key("eth1", base): {RecvBytes: 100, Seconds: 60, Count: 2},
+			},
+		},
+		{
+			name: "from 之前的数据只作为计数器起点",
+			raw: []NetInterfaceFieldsDb{
+				sample("eth0", -2*time.Minute, 100, 0),
+				sample("eth0", 3*time.Minute, 400, 0),
+			},
+			bucket: 5 * time.Minute,
+			want: map[aggKey]trafficStats{
+				key("eth0", base): {RecvBytes: 300, Seconds: 300, Count: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := aggregateTrafficStats(tt.raw, base, tt.bucket)
+			if len(got) != len(tt.want) {
+				t.Fatalf("得到 %d 个时间段，期望 %d 个: %v", len(got), len(tt.want), got)
+			}
+			for k, want := range tt.want {
+				s, ok := got[k]
+				if !ok {
+					t.Errorf("缺少时间段 %v", k)
+					continue
+				}
+				if *s != want {
+					t.Errorf("%s %s: %+v，期望 %+v", k.Interface, k.Bucket.Format(time.TimeOnly), *s, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPrepare5MinDataBps(t *testing.T) {
+	bucket := time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+	data := prepare5MinData(map[aggKey]*trafficStats{
+		{Host: "h1", Interface: "eth0", Bucket: bucket}: {RecvBytes: 300000, SentBytes: 150000, Seconds: 300, Count: 5},
+		// 只有一条数据时没有采集间隔，速率为 0
+		{Host: "h1", Interface: "eth1", Bucket: bucket}: {Count: 1},
+	})
+	for _, d := range data {
+		switch d.Interface {
+		case "eth0":
+			if d.RecvBps != 8000 || d.SentBps != 4000 {
+				t.Errorf("eth0 速率 %v/%v，期望 8000/4000", d.RecvBps, d.SentBps)
+			}
+		case "eth1":
+			if d.RecvBps != 0 || d.SentBps != 0 {
+				t.Errorf("eth1 速率 %v/%v，期望 0", d.RecvBps, d.SentBps)
+			}
+		}
+	}
+}
+
+func TestAlignHours(t *testing.T) {
+	at := func(h, m int) time.Time { return time.Date(2024, 5, 1, h, m, 0, 0, time.Local) }
+	tests := []struct {
+		from, to         time.Time
+		wantFrom, wantTo time.Time
+	}{
+		{at(8, 0), at(10, 0), at(8, 0), at(10, 0)},
+		{at(8, 30), at(10, 15), at(8, 0), at(11, 0)},
+		{at(8, 59), at(9, 1), at(8, 0), at(10, 0)},
+	}
+	for _, tt := range tests {
+		from, to := alignHours(tt.from, tt.to)
+		if !from.Equal(tt.wantFrom) || !to.Equal(tt.wantTo) {
+			t.Errorf("alignHours(%s, %s) = %s, %s", tt.from.Format(time.TimeOnly), tt.to.Format(time.TimeOnly), from.Format(time.TimeOnly), to.Format(time.TimeOnly))
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// 时间戳精度
+// 数据库中的 timestamp 字段统一使用秒（小时统计、异常检测、磁盘预测和保留策略都按秒计算）。
+// 写入时按以下顺序确定时间戳单位并换算为秒：请求参数 precision（s、ms、us、ns、auto）、
+// 配置 ingest.json_precision / ingest.lineprotocol_precision，默认 JSON 为 auto、Line Protocol 为 ns。
+// auto 按数值大小判断单位，适用于 json_timestamp_units 不统一的 Telegraf。
+// 换算后早于 max_past 或晚于 max_future 的数据会被丢弃并计入 moc_ingest_dropped_metrics_total。
+
+// ingestConfig 写入配置
+type ingestConfig struct {
+	JSONPrecision         string             `json:"json_precision"`         // /metrics/json 的时间戳单位，默认 auto
+	LineProtocolPrecision string             `json:"lineprotocol_precision"` // /metrics/lineprotocol 的时间戳单位，默认 ns
+	MaxFuture             string             `json:"max_future"`             // 允许的最大超前时间，默认 10m
+	MaxPast               string             `json:"max_past"`               // 允许的最大滞后时间，默认 168h
+	Rules                 []ingestRuleConfig `json:"rules"`                  // 过滤和标签改写规则，见 filter.go
+}
+
+// 默认的时间范围
+const (
+	defaultMaxFuture = 10 * time.Minute
+	defaultMaxPast   = 7 * 24 * time.Hour
+)
+
+// timestampUnits 支持的时间戳单位，值为每秒的单位数；auto 为 0
+var timestampUnits = map[string]int64{
+	"auto": 0,
+	"s":    1,
+	"ms":   int64(time.Second / time.Millisecond),
+	"us":   int64(time.Second / time.Microsecond),
+	"ns":   int64(time.Second),
+}
+
+// validatePrecision 校验时间戳单位
+func validatePrecision(precision string) error {
+	if _, ok := timestampUnits[precision]; !ok {
+		return fmt.Errorf("不支持的时间戳单位: %q，可选 s、ms、us、ns、auto", precision)
+	}
+	return nil
+}
+
+// detectUnit 按数值大小判断时间戳单位，返回每秒的单位数
+// 秒级时间戳在 5138 年之前小于 1e11，毫秒、微秒依次类推，1973 年之后的各单位时间戳不会落在相邻单位的区间内
+func detectUnit(ts int64) int64 {
+	switch {
+	case ts < 1e11:
+		return 1
+	case ts < 1e14:
+		return timestampUnits["ms"]
+	case ts < 1e17:
+		return timestampUnits["us"]
+	default:
+		return timestampUnits["ns"]
+	}
+}
+
+// toSeconds 将时间戳换算为秒
+func toSeconds(ts int64, precision string) int64 {
+	perSecond := timestampUnits[precision]
+	if perSecond == 0 {
+		perSecond = detectUnit(ts)
+	}
+	return ts / perSecond
+}
+
+// durationOrDefault 解析时间间隔配置，为空或无效时返回默认值
+func durationOrDefault(value string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return d
+	}
+	return def
+}
+
+// endpointPrecision 返回写入接口的时间戳单位：请求参数 precision 优先，其次为配置
+func endpointPrecision(r *http.Request, endpoint string) (string, error) {
+	precision := r.URL.Query().Get("precision")
+	if precision == "" {
+		switch endpoint {
+		case "json":
+			precision = conf().Ingest.JSONPrecision
+		case "lineprotocol":
+			precision = conf().Ingest.LineProtocolPrecision
+		}
+	}
+	if precision == "" {
+		precision = "auto"
+		if endpoint == "lineprotocol" {
+			precision = "ns"
+		}
+	}
+	return precision, validatePrecision(precision)
+}
+
+// normalizeTimestamps 将时间戳换算为秒，丢弃超出允许范围的数据，返回保留的数据；
+// 没有时间戳的数据使用 now。checkPast 为 false 时不检查最大滞后时间（用于重放历史数据）
+func normalizeTimestamps(endpoint string, metrics []TelegrafJson, precision string, now time.Time, checkPast bool) []TelegrafJson {
+	latest := now.Add(durationOrDefault(conf().Ingest.MaxFuture, defaultMaxFuture)).Unix()
+	earliest := now.Add(-durationOrDefault(conf().Ingest.MaxPast, defaultMaxPast)).Unix()
+
+	kept := metrics[:0]
+	for _, m := range metrics {
+		if m.Timestamp <= 0 {
+			m.Timestamp = now.Unix()
+		} else {
+			m.Timestamp = toSeconds(m.Timestamp, precision)
+		}
+		reason := ""
+		switch {
+		case m.Timestamp > latest:
+			reason = "future"
+		case checkPast && m.Timestamp < earliest:
+			reason = "past"
+		}
+		if reason != "" {
+			droppedMetrics.inc(endpoint, reason)
+			ingestLog.Warn("时间戳超出允许范围，丢弃数据", "endpoint", endpoint, "measurement", m.Name,
+				"host", m.Tags["host"], "time", time.Unix(m.Timestamp, 0).Format(time.RFC3339), "precision", precision)
+			continue
+		}
+		kept = append(kept, m)
+	}
+	return kept
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// useConfig 在测试期间使用默认配置，modify 不为空时先修改配置
+func useConfig(t *testing.T, modify func(c *AppConfig)) {
+	t.Helper()
+	c := defaultConfig()
+	if modify != nil {
+		modify(c)
+	}
+	old := config.Swap(c)
+	t.Cleanup(func() { config.Store(old) })
+}
+
+func TestDetectUnit(t *testing.T) {
+	sec := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC).Unix()
+	tests := []struct {
+		name string
+		ts   int64
+		want int64
+	}{
+		{"秒", sec, 1},
+		{"毫秒", sec * 1e3, 1e3},
+		{"微秒", sec * 1e6, 1e6},
+		{"纳秒", sec * 1e9, 1e9},
+		{"秒上界", 1e11 - 1, 1},
+		{"毫秒下界", 1e11, 1e3},
+		{"毫秒上界", 1e14 - 1, 1e3},
+		{"微秒下界", 1e14, 1e6},
+		{"微秒上界", 1e17 - 1, 1e6},
+		{"纳秒下界", 1e17, 1e9},
+		// 1973 年之后的时间戳不会落在相邻单位的区间内
+		{"1973 年毫秒", time.Date(1973, 3, 4, 0, 0, 0, 0, time.UTC).Unix() * 1e3, 1e3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectUnit(tt.ts); got != tt.want {
+				t.Errorf("detectUnit(%d) = %d，期望 %d", tt.ts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToSeconds(t *testing.T) {
+	sec := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC).Unix()
+	tests := []struct {
+		ts        int64
+		precision string
+		want      int64
+	}{
+		{sec, "s", sec},
+		{sec*1e3 + 999, "ms", sec},
+		{sec*1e6 + 999999, "us", sec},
+		{sec*1e9 + 999999999, "ns", sec},
+		{sec * 1e3, "auto", sec},
+		{sec * 1e9, "auto", sec},
+		// 指定单位时不按数值大小判断
+		{sec, "ms", sec / 1e3},
+	}
+	for _, tt := range tests {
+		if got := toSeconds(tt.ts, tt.precision); got != tt.want {
+			t.Errorf("toSeconds(%d, %q) = %d，期望 %d", tt.ts, tt.precision, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeTimestamps(t *testing.T) {
+	useConfig(t, func(c *AppConfig) {
+		c.Ingest.MaxFuture = "10m"
+		c.Ingest.MaxPast = "1h"
+	})
+	now := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		ts        int64
+		precision string
+		checkPast bool
+		want      int64 // -1 表示丢弃
+	}{
+		{"没有时间戳使用 now", 0, "ns", true, now.Unix()},
+		{"纳秒", now.UnixNano(), "ns", true, now.Unix()},
+		{"自动识别毫秒", now.UnixMilli(), "auto", true, now.Unix()},
+		{"允许的最大超前时间", now.Add(10 * time.Minute).Unix(), "s", true, now.Add(10 * time.Minute).Unix()},
+		{"超前", now.Add(11 * time.Minute).Unix(), "s", true, -1},
+		{"滞后", now.Add(-2 * time.Hour).Unix(), "s", true, -1},
+		{"重放不检查滞后", now.Add(-2 * time.Hour).Unix(), "s", false, now.Add(-2 * time.Hour).Unix()},
+		// 单位配置错误时毫秒按秒换算会远超 max_future
+		{"单位错误", now.UnixMilli(), "s", true, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := normalizeTimestamps("test", []TelegrafJson{{Name: "cpu", Timestamp: tt.ts}}, tt.precision, now, tt.checkPast)
+			switch {
+			case tt.want < 0 && len(got) != 0:
+				t.Errorf("期望丢弃，实际保留 %d", got[0].Timestamp)
+			case tt.want >= 0 && len(got) != 1:
+				t.Errorf("期望保留，实际被丢弃")
+			case tt.want >= 0 && got[0].Timestamp != tt.want:
+				t.Errorf("时间戳 %d，期望 %d", got[0].Timestamp, tt.want)
+			}
+		})
+	}
+}
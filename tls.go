@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// HTTPS 和双向 TLS
+// 证书、私钥和客户端 CA 文件变化后自动重新加载，无需重启服务。
+// 启用客户端证书校验时，证书 CN 即为该客户端允许写入的 host 标签（可通过 cn_hosts 覆盖）。
+
+// tlsConfig HTTPS 配置
+type tlsConfig struct {
+	Enable         bool                `json:"enable"`
+	CertFile       string              `json:"cert_file"`       // 服务端证书
+	KeyFile        string              `json:"key_file"`        // 服务端私钥
+	MinVersion     string              `json:"min_version"`     // 最低 TLS 版本: 1.0、1.1、1.2（默认）、1.3
+	CipherSuites   []string            `json:"cipher_suites"`   // 允许的加密套件名称（仅 TLS 1.2 及以下），为空使用 Go 默认值
+	InsecureCipher bool                `json:"insecure_cipher"` // 允许在 cipher_suites 中使用不安全的加密套件（如 RC4、3DES），默认 false
+	ClientCAFile   string              `json:"client_ca_file"`  // 校验客户端证书的 CA 证书
+	ClientAuth     string              `json:"client_auth"`     // none（默认）、verify_if_given、require
+	CNHosts        map[string][]string `json:"cn_hosts"`        // 客户端证书 CN 到允许写入主机的映射，未配置的 CN 只能写入同名主机
+	ReloadInterval string              `json:"reload_interval"` // 检查证书文件变化的间隔，默认 30s
+}
+
+// certReloader 保存当前使用的证书和客户端 CA，并在文件变化时重新加载
+type certReloader struct {
+	cfg tlsConfig
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+// newCertReloader 首次加载证书，失败时返回错误
+func newCertReloader(cfg tlsConfig) (*certReloader, error) {
+	r := &certReloader{cfg: cfg, modTimes: make(map[string]time.Time)}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// load 读取证书、私钥和客户端 CA
+func (r *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("无法加载证书: %v", err)
+	}
+
+	var pool *x509.CertPool
+	if r.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("无法读取客户端 CA: %v", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("客户端 CA 文件中没有有效的证书: %s", r.cfg.ClientCAFile)
+		}
+	}
+
+	modTimes := make(map[string]time.Time)
+	for _, path := range r.files() {
+		if info, err := os.Stat(path); err == nil {
+			modTimes[path] = info.ModTime()
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCA = pool
+	r.modTimes = modTimes
+	r.mu.Unlock()
+	return nil
+}
+
+// files 需要监视变化的文件
+func (r *certReloader) files() []string {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCAFile != "" {
+		files = append(files, r.cfg.ClientCAFile)
+	}
+	return files
+}
+
+// changed 判断文件的修改时间是否变化
+func (r *certReloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, path := range r.files() {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().Equal(r.modTimes[path]) {
+			return true
+		}
+	}
+	return false
+}
+
+// watch 定时检查证书文件，变化后重新加载，加载失败时继续使用旧证书；ctx 结束时停止
+func (r *certReloader) watch(ctx context.Context) {
+	interval := 30 * time.Second
+	if d, err := time.ParseDuration(r.cfg.ReloadInterval); err == nil && d > 0 {
+		interval = d
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !r.changed() {
+			continue
+		}
+		if err := r.load(); err != nil {
+			serverLog.Error("重新加载 TLS 证书失败，继续使用旧证书", "err", err)
+			continue
+		}
+		serverLog.Info("TLS 证书已重新加载")
+	}
+}
+
+// getCertificate 供 tls.Config 使用，返回当前证书
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// parseTLSVersion 解析最低 TLS 版本
+func parseTLSVersion(v string) (uint16, error) {
+	switch v {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("不支持的 TLS 版本: %q", v)
+	}
+}
+
+// parseCipherSuites 将加密套件名称转换为 ID，allowInsecure 为 false 时拒绝不安全的加密套件
+func parseCipherSuites(names []string, allowInsecure bool) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, s := range tls.CipherSuites() {
+		known[s.Name] = s.ID
+	}
+	insecure := make(map[string]uint16)
+	for _, s := range tls.InsecureCipherSuites() {
+		insecure[s.Name] = s.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			if id, ok = insecure[name]; ok && !allowInsecure {
+				return nil, fmt.Errorf("不安全的加密套件: %q，确需使用时设置 insecure_cipher", name)
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("未知的加密套件: %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// parseClientAuth 解析客户端证书校验方式
+func parseClientAuth(v string) (tls.ClientAuthType, error) {
+	switch v {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "verify_if_given":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return tls.NoClientCert, fmt.Errorf("不支持的 client_auth: %q", v)
+	}
+}
+
+// buildTLSConfig 根据配置创建 tls.Config，并启动证书文件监视，ctx 结束时停止监视
+func buildTLSConfig(ctx context.Context, cfg tlsConfig) (*tls.Config, error) {
+	minVersion, err := parseTLSVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	suites, err := parseCipherSuites(cfg.CipherSuites, cfg.InsecureCipher)
+	if err != nil {
+		return nil, err
+	}
+	clientAuth, err := parseClientAuth(cfg.ClientAuth)
+	if err != nil {
+		return nil, err
+	}
+	if clientAuth != tls.NoClientCert && cfg.ClientCAFile == "" {
+		return nil, fmt.Errorf("校验客户端证书需要配置 client_ca_file")
+	}
+
+	reloader, err := newCertReloader(cfg)
+	if err != nil {
+		return nil, err
+	}
+	go reloader.watch(ctx)
+
+	// http.Server 只在自身的 TLSConfig 副本中加入 h2，GetConfigForClient 返回的配置需要自行声明
+	base := &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   suites,
+		ClientAuth:     clientAuth,
+		GetCertificate: reloader.getCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	// 每次握手使用最新的客户端 CA
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := base.Clone()
+		reloader.mu.RLock()
+		c.ClientCAs = reloader.clientCA
+		reloader.mu.RUnlock()
+		return c, nil
+	}
+	return base, nil
+}
+
+// certPrincipal 根据已校验的客户端证书生成调用方，没有客户端证书时返回 nil
+func certPrincipal(r *http.Request) *principal {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	hosts, ok := conf().TLS.CNHosts[cn]
+	if !ok {
+		hosts = []string{cn}
+	}
+	return &principal{Name: "cert:" + cn, Hosts: hosts}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 单位换算
+// 数据库中的容量统一以字节保存，查询接口通过 unit 参数（B、KiB、MiB、GiB）换算后输出。
+
+// byteUnit 字节单位
+type byteUnit struct {
+	name   string
+	factor float64 // 每单位的字节数
+}
+
+// byteUnits 支持的字节单位
+var byteUnits = []byteUnit{
+	{"B", 1},
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+}
+
+// parseByteUnit 解析字节单位，不区分大小写，为空时返回 B
+func parseByteUnit(s string) (byteUnit, error) {
+	if s == "" {
+		return byteUnits[0], nil
+	}
+	for _, u := range byteUnits {
+		if strings.EqualFold(u.name, s) {
+			return u, nil
+		}
+	}
+	return byteUnit{}, fmt.Errorf("不支持的单位: %q，可选 B、KiB、MiB、GiB", s)
+}
+
+// convert 将字节数换算为当前单位
+func (u byteUnit) convert(bytes int64) float64 {
+	return float64(bytes) / u.factor
+}